@@ -4,7 +4,5 @@ go 1.19
 
 require (
 	github.com/creack/pty v1.1.21
-	github.com/gorilla/websocket v1.5.1
+	github.com/gorilla/websocket v1.5.3
 )
-
-require golang.org/x/net v0.17.0 // indirect
@@ -1,6 +1,9 @@
 package auth
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
 	"net/http"
 	"strings"
 
@@ -20,7 +23,7 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		token := getTokenFromRequest(r)
 
 		// Validate token
-		if token == "" || token != config.GetAuthToken() {
+		if !validToken(token) {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusUnauthorized)
 			w.Write([]byte(`{"error": "Unauthorized", "message": "Invalid or missing authentication token"}`))
@@ -32,6 +35,18 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// validToken checks token against the main token and the named tokens
+func validToken(token string) bool {
+	if token == "" {
+		return false
+	}
+	if token == config.GetAuthToken() {
+		return true
+	}
+	_, ok := config.LookupToken(token)
+	return ok
+}
+
 // getTokenFromRequest extracts the token from the request
 // Supports:
 // 1. Authorization header: "Bearer <token>" or "Token <token>"
@@ -60,3 +75,79 @@ func getTokenFromRequest(r *http.Request) string {
 	return ""
 }
 
+// Principal returns a name identifying who made the request: the name of a
+// named token, or a short fingerprint of the main token. The token itself is
+// never exposed.
+func Principal(r *http.Request) string {
+	token := getTokenFromRequest(r)
+	if !config.HasAuthToken() || token == "" {
+		return "anonymous"
+	}
+	if t, ok := config.LookupToken(token); ok {
+		return "token:" + t.Name
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "token:" + hex.EncodeToString(sum[:4])
+}
+
+// TokenName returns the name of the named token the request was made with,
+// empty for the main token or without authentication
+func TokenName(r *http.Request) string {
+	if config.HasAuthToken() {
+		if t, ok := config.LookupToken(getTokenFromRequest(r)); ok {
+			return t.Name
+		}
+	}
+	return ""
+}
+
+// Roles returns the roles of the named token the request was made with
+func Roles(r *http.Request) []string {
+	if config.HasAuthToken() {
+		if t, ok := config.LookupToken(getTokenFromRequest(r)); ok {
+			return t.Roles
+		}
+	}
+	return nil
+}
+
+// RoleAdmin is the token role that may manage other tokens' sessions, jobs
+// and recordings
+const RoleAdmin = "admin"
+
+// IsAdmin reports whether the request was made with a token that has the
+// admin role
+func IsAdmin(r *http.Request) bool {
+	for _, role := range Roles(r) {
+		if role == RoleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccess reports whether the request may see and manage something owned
+// by owner, a Principal: its owner and admins can
+func CanAccess(r *http.Request, owner string) bool {
+	return owner == Principal(r) || IsAdmin(r)
+}
+
+// RunAs returns the "user[:group]" spec processes started by the request run
+// as: the token's mapping if it has one, otherwise the global setting
+func RunAs(r *http.Request) string {
+	if config.HasAuthToken() {
+		if t, ok := config.LookupToken(getTokenFromRequest(r)); ok && t.RunAs != "" {
+			return t.RunAs
+		}
+	}
+	return config.GetRunAs()
+}
+
+// ClientIP returns the IP address of the remote peer
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,75 @@
+// Package cgroup places shells and commands into their own cgroup v2 leaf
+// under a delegated subtree, so CPU, memory, process count and IO limits apply
+// to everything they spawn.
+package cgroup
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/adaptive-scale/webshell/internal/size"
+)
+
+// cpuPeriod is the cpu.max period in microseconds
+const cpuPeriod = 100000
+
+// Events describes what happened in a cgroup while its processes ran
+type Events struct {
+	// OOM counts how often the memory limit could not be kept by reclaim
+	OOM int64 `json:"oom_events"`
+	// OOMKills counts processes killed by the OOM killer
+	OOMKills int64 `json:"oom_kills"`
+	// MemoryMax counts how often memory usage hit memory.max
+	MemoryMax int64 `json:"memory_max_events"`
+	// PidsMax counts forks refused because of pids.max
+	PidsMax int64 `json:"pids_max_events"`
+	// MemoryPeak is the highest memory usage in bytes, if the kernel reports it
+	MemoryPeak int64 `json:"memory_peak_bytes,omitempty"`
+	// CPUUsage and CPUThrottled are the CPU time used and the time spent
+	// throttled by cpu.max
+	CPUUsage     string `json:"cpu_usage,omitempty"`
+	CPUThrottled string `json:"cpu_throttled,omitempty"`
+}
+
+// ParseCPU converts a number of CPUs such as "0.5" or "2" to a cpu.max value;
+// "max" removes the limit
+func ParseCPU(s string) (string, error) {
+	if s == "" || s == "max" {
+		return s, nil
+	}
+	cpus, err := strconv.ParseFloat(s, 64)
+	if err != nil || cpus <= 0 {
+		return "", fmt.Errorf("invalid CPU limit %q, expected a positive number of CPUs", s)
+	}
+	quota := int64(cpus * cpuPeriod)
+	if quota < 1000 {
+		quota = 1000
+	}
+	return fmt.Sprintf("%d %d", quota, cpuPeriod), nil
+}
+
+// ParseBytes converts a size such as "512M" or "2G" to a number of bytes;
+// "max" removes the limit
+func ParseBytes(s string) (string, error) {
+	if s == "" || s == "max" {
+		return s, nil
+	}
+	n, err := size.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(n, 10), nil
+}
+
+// ParseRange checks that s is an integer between min and max, or "max" when
+// allowMax is set
+func ParseRange(s string, min, max int64, allowMax bool) (string, error) {
+	if s == "" || (allowMax && s == "max") {
+		return s, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < min || n > max {
+		return "", fmt.Errorf("invalid value %q, expected %d to %d", s, min, max)
+	}
+	return s, nil
+}
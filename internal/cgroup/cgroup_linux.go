@@ -0,0 +1,198 @@
+package cgroup
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/adaptive-scale/webshell/internal/config"
+)
+
+// cgroup2Magic is the filesystem type of the cgroup v2 hierarchy
+const cgroup2Magic = 0x63677270
+
+// removeTimeout bounds how long Close waits for the processes of a cgroup to
+// go away before giving up on removing it
+const removeTimeout = 2 * time.Second
+
+// Group is the cgroup of one shell or command. A nil *Group is valid and does
+// nothing, which is what New returns when cgroups are disabled.
+type Group struct {
+	path string
+}
+
+// Setup checks that the configured root is a writable cgroup v2 directory and
+// enables the controllers the limits need, plus memory and pids for event
+// reporting, for its children
+func Setup() error {
+	root := config.GetCgroupRoot()
+	if root == "" {
+		return nil
+	}
+
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(root, &fs); err != nil {
+		return err
+	}
+	if fs.Type != cgroup2Magic {
+		return fmt.Errorf("%s is not a cgroup v2 directory", root)
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, "cgroup.controllers"))
+	if err != nil {
+		return err
+	}
+	available := make(map[string]bool)
+	for _, name := range strings.Fields(string(data)) {
+		available[name] = true
+	}
+
+	limits := config.GetCgroupLimits()
+	required := map[string]bool{
+		"cpu":    limits.CPUMax != "",
+		"memory": limits.MemoryMax != "",
+		"pids":   limits.PidsMax != "",
+		"io":     limits.IOWeight != "",
+	}
+	var enable []string
+	for _, name := range []string{"cpu", "memory", "pids", "io"} {
+		switch {
+		case available[name]:
+			enable = append(enable, "+"+name)
+		case required[name]:
+			return fmt.Errorf("cgroup controller %s is not available in %s, enable it in the parent's cgroup.subtree_control", name, root)
+		}
+	}
+	if len(enable) == 0 {
+		return nil
+	}
+	return os.WriteFile(filepath.Join(root, "cgroup.subtree_control"), []byte(strings.Join(enable, " ")), 0)
+}
+
+// New creates a cgroup named prefix-<random> under the configured root with
+// the configured limits. It returns nil when cgroups are disabled.
+func New(prefix string) (*Group, error) {
+	root := config.GetCgroupRoot()
+	if root == "" {
+		return nil, nil
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	g := &Group{path: filepath.Join(root, prefix+"-"+hex.EncodeToString(suffix))}
+	if err := os.Mkdir(g.path, 0755); err != nil {
+		return nil, err
+	}
+
+	limits := config.GetCgroupLimits()
+	for file, value := range map[string]string{
+		"cpu.max":    limits.CPUMax,
+		"memory.max": limits.MemoryMax,
+		"pids.max":   limits.PidsMax,
+		"io.weight":  limits.IOWeight,
+	} {
+		if value == "" {
+			continue
+		}
+		if err := g.write(file, value); err != nil {
+			os.Remove(g.path)
+			return nil, fmt.Errorf("failed to set %s: %w", file, err)
+		}
+	}
+	if limits.MemoryMax != "" {
+		// Without swap limit the memory limit is easily bypassed; ignore
+		// kernels without swap accounting
+		g.write("memory.swap.max", "0")
+	}
+	return g, nil
+}
+
+// Events returns what happened in the cgroup so far, or nil for a nil Group
+func (g *Group) Events() *Events {
+	if g == nil {
+		return nil
+	}
+	var events Events
+	memory := g.readKeyed("memory.events")
+	events.OOM = memory["oom"]
+	events.OOMKills = memory["oom_kill"]
+	events.MemoryMax = memory["max"]
+	events.PidsMax = g.readKeyed("pids.events")["max"]
+	if data, err := os.ReadFile(filepath.Join(g.path, "memory.peak")); err == nil {
+		events.MemoryPeak, _ = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	}
+	cpu := g.readKeyed("cpu.stat")
+	if usage, ok := cpu["usage_usec"]; ok {
+		events.CPUUsage = (time.Duration(usage) * time.Microsecond).String()
+	}
+	if throttled, ok := cpu["throttled_usec"]; ok && throttled > 0 {
+		events.CPUThrottled = (time.Duration(throttled) * time.Microsecond).String()
+	}
+	return &events
+}
+
+// Close kills any process left in the cgroup and removes it
+func (g *Group) Close() error {
+	if g == nil {
+		return nil
+	}
+	// cgroup.kill is available from Linux 5.14
+	g.write("cgroup.kill", "1")
+
+	deadline := time.Now().Add(removeTimeout)
+	for {
+		err := os.Remove(g.path)
+		if err == nil || errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// String returns the path of the cgroup
+func (g *Group) String() string {
+	if g == nil {
+		return ""
+	}
+	return g.path
+}
+
+func (g *Group) write(file, value string) error {
+	return os.WriteFile(filepath.Join(g.path, file), []byte(value), 0)
+}
+
+// readKeyed reads a flat keyed file such as memory.events; missing files
+// yield an empty map
+func (g *Group) readKeyed(file string) map[string]int64 {
+	values := make(map[string]int64)
+	f, err := os.Open(filepath.Join(g.path, file))
+	if err != nil {
+		return values
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if n, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			values[fields[0]] = n
+		}
+	}
+	return values
+}
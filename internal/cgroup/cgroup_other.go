@@ -0,0 +1,52 @@
+//go:build !linux
+
+package cgroup
+
+import (
+	"errors"
+	"os/exec"
+
+	"github.com/adaptive-scale/webshell/internal/config"
+)
+
+var errUnsupported = errors.New("cgroups are only supported on Linux")
+
+// Group is the cgroup of one shell or command. Only nil groups exist outside
+// Linux.
+type Group struct{}
+
+// Setup fails if cgroups are configured, as they are only supported on Linux
+func Setup() error {
+	if config.GetCgroupRoot() != "" {
+		return errUnsupported
+	}
+	return nil
+}
+
+// New returns nil when cgroups are disabled and fails otherwise
+func New(prefix string) (*Group, error) {
+	if config.GetCgroupRoot() != "" {
+		return nil, errUnsupported
+	}
+	return nil, nil
+}
+
+// Start runs start
+func (g *Group) Start(cmd *exec.Cmd, start func() error) error {
+	return start()
+}
+
+// Events returns nil
+func (g *Group) Events() *Events {
+	return nil
+}
+
+// Close does nothing
+func (g *Group) Close() error {
+	return nil
+}
+
+// String returns ""
+func (g *Group) String() string {
+	return ""
+}
@@ -0,0 +1,25 @@
+//go:build linux && !go1.20
+
+package cgroup
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+// Start runs start, which must start cmd, then moves cmd into the cgroup.
+// Go releases before 1.20 cannot spawn a process into a cgroup, so children
+// forked before the move are not limited. If the move fails cmd is killed and
+// reaped. A nil Group just runs start.
+func (g *Group) Start(cmd *exec.Cmd, start func() error) error {
+	if err := start(); err != nil || g == nil {
+		return err
+	}
+	if err := g.write("cgroup.procs", strconv.Itoa(cmd.Process.Pid)); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("failed to move process into cgroup: %w", err)
+	}
+	return nil
+}
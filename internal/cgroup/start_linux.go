@@ -0,0 +1,38 @@
+//go:build linux && go1.20
+
+package cgroup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// Start runs start, which must start cmd, with cmd spawned directly into the
+// cgroup (CLONE_INTO_CGROUP), so nothing it forks can escape the limits. This
+// needs Linux 5.7 or later. A nil Group just runs start.
+func (g *Group) Start(cmd *exec.Cmd, start func() error) error {
+	if g == nil {
+		return start()
+	}
+
+	dir, err := os.OpenFile(g.path, os.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(dir.Fd())
+
+	err = start()
+	if errors.Is(err, syscall.ENOSYS) {
+		return fmt.Errorf("starting processes in a cgroup needs Linux 5.7 or later: %w", err)
+	}
+	return err
+}
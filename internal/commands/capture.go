@@ -0,0 +1,114 @@
+package commands
+
+import (
+	"strings"
+)
+
+// Which part of the output is kept when it exceeds the limit
+const (
+	KeepHead = "head"
+	KeepTail = "tail"
+	KeepBoth = "both"
+)
+
+// capture collects output as runs of one stream, keeping at most head bytes
+// from the start and tail bytes from the end and counting what is dropped in
+// between
+type capture struct {
+	head, tail         int64
+	headRuns, tailRuns []chunk
+	headSize, tailSize int64
+	dropped            int64
+}
+
+// chunk is a stretch of output from one stream
+type chunk struct {
+	stream string
+	data   []byte
+}
+
+// newCapture returns a capture keeping limit bytes as keep says, everything
+// if limit is 0
+func newCapture(limit int64, keep string) *capture {
+	switch {
+	case limit <= 0:
+		return &capture{head: -1}
+	case keep == KeepHead:
+		return &capture{head: limit}
+	case keep == KeepTail:
+		return &capture{tail: limit}
+	default:
+		return &capture{head: limit / 2, tail: limit - limit/2}
+	}
+}
+
+func (c *capture) write(stream string, data []byte) {
+	if c.head < 0 {
+		c.headRuns = appendRun(c.headRuns, stream, data)
+		return
+	}
+	if n := c.head - c.headSize; n > 0 {
+		if n > int64(len(data)) {
+			n = int64(len(data))
+		}
+		c.headRuns = appendRun(c.headRuns, stream, data[:n])
+		c.headSize += n
+		data = data[n:]
+	}
+	if len(data) == 0 {
+		return
+	}
+
+	if excess := int64(len(data)) - c.tail; excess > 0 {
+		c.dropped += excess
+		data = data[excess:]
+	}
+	c.tailRuns = appendRun(c.tailRuns, stream, data)
+	c.tailSize += int64(len(data))
+	for c.tailSize > c.tail {
+		excess := c.tailSize - c.tail
+		first := &c.tailRuns[0]
+		if int64(len(first.data)) <= excess {
+			excess = int64(len(first.data))
+			c.tailRuns = c.tailRuns[1:]
+		} else {
+			first.data = first.data[excess:]
+		}
+		c.tailSize -= excess
+		c.dropped += excess
+	}
+}
+
+// appendRun adds data to the last run if it is from the same stream
+func appendRun(runs []chunk, stream string, data []byte) []chunk {
+	if len(data) == 0 {
+		return runs
+	}
+	if last := len(runs) - 1; last >= 0 && runs[last].stream == stream {
+		runs[last].data = append(runs[last].data, data...)
+		return runs
+	}
+	return append(runs, chunk{stream: stream, data: append([]byte(nil), data...)})
+}
+
+// fill sets the output fields of response from the kept output
+func (c *capture) fill(response *CommandResponse, interleaved bool) {
+	var stdout, stderr, combined strings.Builder
+	for _, runs := range [][]chunk{c.headRuns, c.tailRuns} {
+		for _, ch := range runs {
+			combined.Write(ch.data)
+			if ch.stream == "stderr" {
+				stderr.Write(ch.data)
+			} else {
+				stdout.Write(ch.data)
+			}
+			if interleaved {
+				response.Interleaved = append(response.Interleaved, Segment{Stream: ch.stream, Data: string(ch.data)})
+			}
+		}
+	}
+	response.Output = combined.String() // Keep full output including newlines
+	response.Stdout, response.Stderr = stdout.String(), stderr.String()
+	response.Truncated = c.dropped > 0
+	response.DroppedBytes = c.dropped
+}
@@ -2,11 +2,32 @@ package commands
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
 	"os/exec"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/adaptive-scale/webshell/internal/cgroup"
+	"github.com/adaptive-scale/webshell/internal/config"
+	"github.com/adaptive-scale/webshell/internal/process"
+	"github.com/adaptive-scale/webshell/internal/spill"
 )
 
+// drainTimeout bounds how long output is read after the process group is gone,
+// in case a process that escaped the group still holds the pipe open
+const drainTimeout = time.Second
+
+// Segment is a run of output from one stream
+type Segment struct {
+	Stream string `json:"stream"`
+	Data   string `json:"data"`
+}
+
 // CommandResponse represents the structure of command execution responses
 type CommandResponse struct {
 	Success   bool   `json:"success"`
@@ -16,22 +37,193 @@ type CommandResponse struct {
 	Duration  string `json:"duration"`
 	Timestamp string `json:"timestamp"`
 	Command   string `json:"command"`
+	// Signal names the signal that killed the command, if any
+	Signal string `json:"signal,omitempty"`
+	// TimedOut is set when the command was terminated for running too long
+	TimedOut bool `json:"timed_out"`
+	// StartFailed is set when the command could not be started at all
+	StartFailed bool `json:"start_failed,omitempty"`
+	// Stdout and Stderr hold the streams separately, Output both in the order
+	// they were read
+	Stdout      string `json:"stdout,omitempty"`
+	Stderr      string `json:"stderr,omitempty"`
+	StdoutBytes int64  `json:"stdout_bytes"`
+	StderrBytes int64  `json:"stderr_bytes"`
+	// Interleaved lists the output as consecutive chunks of one stream, if
+	// requested with Options.Interleaved
+	Interleaved []Segment `json:"interleaved,omitempty"`
+	// Truncated is set when output past the output limit was dropped,
+	// DroppedBytes counts it
+	Truncated    bool  `json:"truncated,omitempty"`
+	DroppedBytes int64 `json:"dropped_bytes,omitempty"`
+	// FullOutput describes where the untruncated output can be downloaded,
+	// when an output directory is configured
+	FullOutput *spill.Info `json:"full_output,omitempty"`
+	// Cgroup reports OOM and limit events when cgroups are configured
+	Cgroup *cgroup.Events `json:"cgroup,omitempty"`
 }
 
-// executeCommand executes a command with timeout and returns the result
-// Timeout is increased to 300 seconds to support script execution
-func ExecuteCommand(command string, args []string) CommandResponse {
+// Options describe a command to execute
+type Options struct {
+	Command string
+	Args    []string
+	// Stdin is the command's standard input, none if nil
+	Stdin io.Reader
+	// Env holds extra KEY=VALUE variables, which take precedence over the
+	// server's environment
+	Env []string
+	// Dir is the working directory, the server's if empty
+	Dir string
+	// Timeout bounds how long the command runs; 0 or anything above the
+	// configured execute timeout means the configured timeout
+	Timeout time.Duration
+	// RunAs is the user the command runs as, the server's user if nil
+	RunAs *process.Credential
+	// Interleaved records the order of stdout and stderr chunks in the
+	// response's Interleaved field
+	Interleaved bool
+	// MaxOutput bounds how many bytes of output ExecuteCommand keeps; 0 or
+	// anything above the configured max output means the configured limit
+	MaxOutput int64
+	// Keep is KeepHead, KeepTail or KeepBoth (the default) and tells which
+	// part of the output is kept when it exceeds MaxOutput
+	Keep string
+	// Owner is the Principal the command runs for; only it and admins may
+	// download the full output kept for it
+	Owner string
+}
+
+// timeout returns the effective timeout of the command, 0 for none
+func (o Options) timeout() time.Duration {
+	limit := config.GetExecuteTimeout()
+	if o.Timeout > 0 && (o.Timeout < limit || limit <= 0) {
+		return o.Timeout
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return limit
+}
+
+// maxOutput returns the effective output limit of the command, 0 for none
+func (o Options) maxOutput() int64 {
+	limit := config.GetMaxOutput()
+	if o.MaxOutput > 0 && (o.MaxOutput < limit || limit <= 0) {
+		return o.MaxOutput
+	}
+	return limit
+}
+
+// commandLine returns the command and its arguments as one string
+func (o Options) commandLine() string {
+	return o.Command + " " + strings.Join(o.Args, " ")
+}
+
+// ExecuteCommand executes a command with timeout and returns the result
+// stdout and stderr are captured separately and combined in Output
+// The timeout defaults to the configured execute timeout (300 seconds) to
+// support script execution
+// Output beyond the output limit is dropped from the middle, start or end as
+// Options.Keep says; with an output directory configured the full output is
+// written to a file that can be downloaded for a while
+// The command runs in its own process group, which is terminated when the
+// command exits or times out so no background processes are left behind
+// When cgroups are configured the command runs in its own cgroup, whose events
+// are reported in the response
+func ExecuteCommand(opts Options) CommandResponse {
+	limit := opts.maxOutput()
+	output := newCapture(limit, opts.Keep)
+	var full *spill.File
+	if limit > 0 {
+		var err error
+		if full, err = spill.Create(opts.Owner); err != nil {
+			log.Printf("Failed to create output file: %v", err)
+		}
+	}
+
+	response := StreamCommand(context.Background(), opts, func(stream string, data []byte) {
+		output.write(stream, data)
+		full.Write(data)
+	})
+	output.fill(&response, opts.Interleaved)
+
+	if !response.Truncated {
+		full.Discard()
+		return response
+	}
+	info, err := full.Keep()
+	if err != nil {
+		log.Printf("Failed to keep full output: %v", err)
+	}
+	response.FullOutput = info
+	return response
+}
+
+// StreamCommand executes a command like ExecuteCommand, but passes stdout and
+// stderr to onOutput as they arrive instead of collecting them, so the response
+// only has their sizes. onOutput is never called concurrently and must not keep data.
+// The command is terminated when ctx is done.
+func StreamCommand(ctx context.Context, opts Options, onOutput func(stream string, data []byte)) CommandResponse {
+	var mu sync.Mutex
+	stdout := &streamWriter{mu: &mu, stream: "stdout", onOutput: onOutput}
+	stderr := &streamWriter{mu: &mu, stream: "stderr", onOutput: onOutput}
+	response := execute(ctx, opts, stdout, stderr)
+	response.StdoutBytes, response.StderrBytes = stdout.written, stderr.written
+	return response
+}
+
+// streamWriter passes everything written to it to onOutput, tagged with its
+// stream, and counts the bytes
+type streamWriter struct {
+	mu       *sync.Mutex
+	stream   string
+	onOutput func(stream string, data []byte)
+	written  int64
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onOutput(s.stream, p)
+	s.written += int64(len(p))
+	return len(p), nil
+}
+
+// execute runs the command, writing its output to stdout and stderr, and
+// returns the result without Output
+func execute(ctx context.Context, opts Options, stdout, stderr io.Writer) CommandResponse {
 	start := time.Now()
 
-	// Create context with timeout (300 seconds for script execution)
-	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Second)
+	// Create context with timeout, unless commands may run forever
+	cancel := func() {}
+	if timeout := opts.timeout(); timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
 	defer cancel()
 
 	// Create command
-	cmd := exec.CommandContext(ctx, command, args...)
+	cmd := exec.Command(opts.Command, opts.Args...)
+	cmd.Dir = opts.Dir
+	process.Apply(cmd, opts.RunAs)
+	cmd.Env = append(cmd.Env, opts.Env...)
+	process.SetGroup(cmd)
+
+	group, err := cgroup.New("exec")
+	if err != nil {
+		return CommandResponse{
+			Error:       "failed to create cgroup: " + err.Error(),
+			ExitCode:    -1,
+			Duration:    time.Since(start).String(),
+			Timestamp:   time.Now().UTC().Format(time.RFC3339),
+			Command:     opts.commandLine(),
+			StartFailed: true,
+		}
+	}
+	defer group.Close()
 
 	// Execute command
-	output, err := cmd.CombinedOutput()
+	err = run(ctx, cmd, group, opts.Stdin, stdout, stderr)
+	events := group.Events()
 
 	// Calculate duration
 	duration := time.Since(start)
@@ -39,16 +231,143 @@ func ExecuteCommand(command string, args []string) CommandResponse {
 	// Prepare response
 	response := CommandResponse{
 		Success:   err == nil,
-		Output:    string(output), // Keep full output including newlines
 		ExitCode:  cmd.ProcessState.ExitCode(),
 		Duration:  duration.String(),
 		Timestamp: time.Now().UTC().Format(time.RFC3339),
-		Command:   command + " " + strings.Join(args, " "),
+		Command:   opts.commandLine(),
+		Cgroup:    events,
+	}
+
+	if cmd.ProcessState != nil {
+		response.Signal = process.ExitSignal(cmd.ProcessState)
 	}
 
 	if err != nil {
 		response.Error = err.Error()
+		var startErr *startError
+		switch {
+		case errors.As(err, &startErr):
+			response.StartFailed = true
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			response.TimedOut = true
+			response.Error = fmt.Sprintf("timed out after %s: %s", opts.timeout(), response.Error)
+		}
+		if events != nil && events.OOMKills > 0 {
+			response.Error += " (out of memory: the cgroup memory limit was reached)"
+		}
 	}
 
 	return response
 }
+
+// startError is returned by run when the command could not be started
+type startError struct {
+	err error
+}
+
+func (e *startError) Error() string {
+	return e.err.Error()
+}
+
+func (e *startError) Unwrap() error {
+	return e.err
+}
+
+// run starts cmd, feeds it stdin and copies its output to stdout and stderr.
+// Input and output go through pipes owned here rather than by exec.Cmd, so
+// waiting for the command does not also wait for background processes that
+// inherited them. Those are terminated with the rest of the process group once
+// the command exits or ctx is done. The command is moved into group right
+// after it starts.
+func run(ctx context.Context, cmd *exec.Cmd, group *cgroup.Group, stdin io.Reader, stdout, stderr io.Writer) error {
+	// readers are our ends of the output pipes, copied to targets; childEnds
+	// are passed to the command and closed here once it has started
+	var readers, childEnds []*os.File
+	var targets []io.Writer
+	var input *os.File
+	fail := func(err error) error {
+		closeAll(readers)
+		closeAll(childEnds)
+		if input != nil {
+			input.Close()
+		}
+		return err
+	}
+
+	outputPipe := func(dst io.Writer) (*os.File, error) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		readers, childEnds, targets = append(readers, r), append(childEnds, w), append(targets, dst)
+		return w, nil
+	}
+	var err error
+	if cmd.Stdout, err = outputPipe(stdout); err != nil {
+		return fail(err)
+	}
+	if cmd.Stderr, err = outputPipe(stderr); err != nil {
+		return fail(err)
+	}
+	if stdin != nil {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return fail(err)
+		}
+		childEnds, input = append(childEnds, r), w
+		cmd.Stdin = r
+	}
+
+	err = group.Start(cmd, cmd.Start)
+	closeAll(childEnds)
+	childEnds = nil
+	if err != nil {
+		return fail(&startError{err})
+	}
+
+	// Input is written until it ends or the command stops reading it
+	if input != nil {
+		go func() {
+			io.Copy(input, stdin)
+			input.Close()
+		}()
+	}
+
+	var copies sync.WaitGroup
+	for i := range readers {
+		copies.Add(1)
+		go func(r *os.File, dst io.Writer) {
+			defer copies.Done()
+			io.Copy(dst, r)
+		}(readers[i], targets[i])
+	}
+	drained := make(chan struct{})
+	go func() {
+		copies.Wait()
+		close(drained)
+	}()
+
+	// Background processes are ended with the command, or all of them when
+	// the context is done
+	err = process.Wait(ctx, cmd, config.GetKillGracePeriod())
+	if input != nil {
+		// Unblock a write nobody is left to read
+		input.Close()
+	}
+
+	select {
+	case <-drained:
+	case <-time.After(drainTimeout):
+		closeAll(readers)
+		<-drained
+	}
+	closeAll(readers)
+
+	return err
+}
+
+func closeAll(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
@@ -0,0 +1,44 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// running reports whether a non-zombie process has arg in its command line
+func running(arg string) bool {
+	paths, _ := filepath.Glob("/proc/[0-9]*/cmdline")
+	for _, path := range paths {
+		cmdline, err := os.ReadFile(path)
+		if err != nil || !bytes.Contains(cmdline, []byte(arg)) {
+			continue
+		}
+		stat, err := os.ReadFile(filepath.Join(filepath.Dir(path), "stat"))
+		if err == nil && !bytes.Contains(stat, []byte(") Z ")) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestExecuteCommandKillsBackgroundProcesses(t *testing.T) {
+	// A duration unique to this run identifies the background sleeps
+	marker := fmt.Sprintf("999.%d", os.Getpid())
+
+	start := time.Now()
+	response := ExecuteCommand(Options{Command: "/bin/sh", Args: []string{"-c", "sleep " + marker + " & sleep " + marker + " & echo started"}})
+
+	if !response.Success || response.Output != "started\n" {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("ExecuteCommand waited %v for background processes", elapsed)
+	}
+	if running(marker) {
+		t.Fatalf("background process survived the command")
+	}
+}
@@ -0,0 +1,153 @@
+//go:build !windows
+
+package commands
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adaptive-scale/webshell/internal/config"
+	"github.com/adaptive-scale/webshell/internal/process"
+)
+
+func TestStreamCommandTagsStreams(t *testing.T) {
+	var chunks []string
+	response := StreamCommand(context.Background(), Options{Command: "sh", Args: []string{"-c", "echo out; sleep 0.1; echo err >&2; exit 2"}}, func(stream string, data []byte) {
+		chunks = append(chunks, stream+":"+string(data))
+	})
+
+	if got := strings.Join(chunks, ""); got != "stdout:out\nstderr:err\n" {
+		t.Errorf("chunks = %q", got)
+	}
+	if response.ExitCode != 2 || response.Success || response.Output != "" {
+		t.Errorf("response = %+v", response)
+	}
+}
+
+func TestExecuteCommandOptions(t *testing.T) {
+	response := ExecuteCommand(Options{
+		Command: "sh",
+		Args:    []string{"-c", `read line; echo "$line $GREETING $(pwd)"`},
+		Stdin:   strings.NewReader("hello\n"),
+		Env:     []string{"GREETING=world"},
+		Dir:     "/",
+	})
+	if response.Output != "hello world /\n" {
+		t.Errorf("output = %q, error = %q", response.Output, response.Error)
+	}
+}
+
+func TestExecuteCommandRunAsHidesSecrets(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("switching users requires root")
+	}
+	runAs, err := process.LookupUser("nobody")
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Setenv("AUTH_TOKEN", "secret-token")
+	t.Setenv("TOKENS_FILE", "/etc/webshell/tokens.json")
+	t.Setenv("POLICY_FILE", "/etc/webshell/policy.json")
+
+	response := ExecuteCommand(Options{Command: "env", Env: []string{"GREETING=world"}, RunAs: runAs})
+	if !response.Success {
+		t.Fatalf("env failed: %+v", response)
+	}
+	for _, key := range []string{"AUTH_TOKEN=", "TOKENS_FILE=", "POLICY_FILE="} {
+		if strings.Contains(response.Output, key) {
+			t.Errorf("run-as child sees %s\n%s", key, response.Output)
+		}
+	}
+	for _, kv := range []string{"USER=nobody", "GREETING=world"} {
+		if !strings.Contains(response.Output, kv+"\n") {
+			t.Errorf("run-as child is missing %s\n%s", kv, response.Output)
+		}
+	}
+}
+
+func TestExecuteCommandTimeout(t *testing.T) {
+	start := time.Now()
+	response := ExecuteCommand(Options{Command: "sleep", Args: []string{"10"}, Timeout: 100 * time.Millisecond})
+	if response.Success || !response.TimedOut || time.Since(start) > 5*time.Second {
+		t.Errorf("command ran for %v: %+v", time.Since(start), response)
+	}
+}
+
+func TestExecuteCommandWithoutTimeout(t *testing.T) {
+	defer config.SetExecuteTimeout(config.GetExecuteTimeout())
+	config.SetExecuteTimeout(0)
+
+	response := ExecuteCommand(Options{Command: "sleep", Args: []string{"0.2"}})
+	if !response.Success || response.TimedOut {
+		t.Errorf("unlimited timeout: %+v", response)
+	}
+
+	// A requested timeout still applies
+	response = ExecuteCommand(Options{Command: "sleep", Args: []string{"10"}, Timeout: 100 * time.Millisecond})
+	if response.Success || !response.TimedOut {
+		t.Errorf("requested timeout: %+v", response)
+	}
+}
+
+func TestExecuteCommandFailures(t *testing.T) {
+	response := ExecuteCommand(Options{Command: "false"})
+	if response.Success || response.ExitCode != 1 || response.TimedOut || response.StartFailed {
+		t.Errorf("false: %+v", response)
+	}
+
+	response = ExecuteCommand(Options{Command: "/nonexistent/command"})
+	if response.Success || !response.StartFailed || response.TimedOut {
+		t.Errorf("missing command: %+v", response)
+	}
+}
+
+func TestExecuteCommandSeparatesStreams(t *testing.T) {
+	response := ExecuteCommand(Options{
+		Command:     "sh",
+		Args:        []string{"-c", "echo '{}'; sleep 0.1; echo warning >&2; sleep 0.1; echo done"},
+		Interleaved: true,
+	})
+
+	if response.Stdout != "{}\ndone\n" || response.Stderr != "warning\n" {
+		t.Errorf("stdout = %q, stderr = %q", response.Stdout, response.Stderr)
+	}
+	if response.Output != "{}\nwarning\ndone\n" {
+		t.Errorf("output = %q", response.Output)
+	}
+	if response.StdoutBytes != 8 || response.StderrBytes != 8 {
+		t.Errorf("stdout_bytes = %d, stderr_bytes = %d", response.StdoutBytes, response.StderrBytes)
+	}
+	want := []Segment{{"stdout", "{}\n"}, {"stderr", "warning\n"}, {"stdout", "done\n"}}
+	if len(response.Interleaved) != len(want) {
+		t.Fatalf("interleaved = %+v", response.Interleaved)
+	}
+	for i := range want {
+		if response.Interleaved[i] != want[i] {
+			t.Errorf("interleaved[%d] = %+v, want %+v", i, response.Interleaved[i], want[i])
+		}
+	}
+}
+
+func TestExecuteCommandTruncatesOutput(t *testing.T) {
+	for _, test := range []struct {
+		script, keep   string
+		limit          int64
+		output, stderr string
+	}{
+		{"printf 0123456789; printf abcdefghij", KeepBoth, 8, "0123ghij", ""},
+		{"printf 0123456789; printf abcdefghij", KeepHead, 8, "01234567", ""},
+		{"printf 0123456789; printf abcdefghij", KeepTail, 8, "cdefghij", ""},
+		{"printf 0123456789; sleep 0.1; printf abcdefghij >&2", KeepBoth, 9, "0123fghij", "fghij"},
+	} {
+		response := ExecuteCommand(Options{Command: "sh", Args: []string{"-c", test.script}, MaxOutput: test.limit, Keep: test.keep})
+		if response.Output != test.output || response.Stderr != test.stderr {
+			t.Errorf("keep %s: output = %q, stderr = %q", test.keep, response.Output, response.Stderr)
+		}
+		if !response.Truncated || response.DroppedBytes != 20-test.limit {
+			t.Errorf("keep %s: truncated = %v, dropped = %d", test.keep, response.Truncated, response.DroppedBytes)
+		}
+	}
+}
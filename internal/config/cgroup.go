@@ -0,0 +1,34 @@
+package config
+
+// CgroupLimits are the resource limits applied to the cgroup of every shell and
+// command, as written to the cgroup v2 interface files. Empty values are left
+// at the kernel default.
+type CgroupLimits struct {
+	CPUMax    string // cpu.max, e.g. "50000 100000" for half a CPU
+	MemoryMax string // memory.max in bytes
+	PidsMax   string // pids.max
+	IOWeight  string // io.weight, 1-10000
+}
+
+var (
+	cgroupRoot   string
+	cgroupLimits CgroupLimits
+)
+
+// SetCgroup sets the delegated cgroup v2 directory under which each shell and
+// command gets its own cgroup, and the limits applied to those cgroups. An
+// empty root disables cgroups.
+func SetCgroup(root string, limits CgroupLimits) {
+	cgroupRoot = root
+	cgroupLimits = limits
+}
+
+// GetCgroupRoot returns the delegated cgroup v2 directory, empty if disabled
+func GetCgroupRoot() string {
+	return cgroupRoot
+}
+
+// GetCgroupLimits returns the limits applied to each cgroup
+func GetCgroupLimits() CgroupLimits {
+	return cgroupLimits
+}
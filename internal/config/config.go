@@ -18,7 +18,21 @@ func GetAuthToken() string {
 
 // HasAuthToken checks if authentication token is configured
 func HasAuthToken() bool {
-	return authToken != ""
+	return authToken != "" || len(tokens) > 0
+}
+
+// secretEnv lists server settings that must never reach spawned processes
+var secretEnv = []string{"AUTH_TOKEN", "TOKENS_FILE", "POLICY_FILE", "CERT_FILE", "KEY_FILE"}
+
+// IsSecretEnv reports whether the environment variable key holds a server
+// setting that is kept from shells and commands
+func IsSecretEnv(key string) bool {
+	for _, secret := range secretEnv {
+		if key == secret {
+			return true
+		}
+	}
+	return false
 }
 
 // getEnv gets an environment variable or returns a default value
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"time"
+)
+
+var (
+	executeTimeout      = 300 * time.Second
+	executeQueueTimeout time.Duration
+	failStatus          int
+)
+
+// SetExecuteTimeout sets how long commands may run, 0 for no limit. It is
+// the default for requests that do not ask for a timeout and the maximum for
+// those that do.
+func SetExecuteTimeout(d time.Duration) {
+	executeTimeout = d
+}
+
+// GetExecuteTimeout returns how long commands may run
+func GetExecuteTimeout() time.Duration {
+	return executeTimeout
+}
+
+// SetExecuteQueueTimeout sets how long a command waits for a free slot before
+// it is rejected, 0 rejects it immediately
+func SetExecuteQueueTimeout(d time.Duration) {
+	executeQueueTimeout = d
+}
+
+// GetExecuteQueueTimeout returns how long a command waits for a free slot
+func GetExecuteQueueTimeout() time.Duration {
+	return executeQueueTimeout
+}
+
+// SetFailStatus sets the HTTP status /execute answers with when a command
+// exits non-zero, 0 to answer 200 unless a request asks otherwise
+func SetFailStatus(status int) {
+	failStatus = status
+}
+
+// GetFailStatus returns the HTTP status for failed commands, 0 when off
+func GetFailStatus() int {
+	return failStatus
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"time"
+)
+
+var (
+	jobsDir        string
+	maxJobs        = 100
+	jobRetention   = time.Hour
+	jobOutputLimit = 1 << 20
+)
+
+// SetJobsDir sets the directory job output and metadata are kept in, so
+// finished jobs survive restarts. An empty directory keeps jobs in memory.
+func SetJobsDir(dir string) {
+	jobsDir = dir
+}
+
+// GetJobsDir returns the directory jobs are kept in, empty if in memory
+func GetJobsDir() string {
+	return jobsDir
+}
+
+// SetMaxJobs sets how many jobs, running or finished, are kept
+func SetMaxJobs(n int) {
+	maxJobs = n
+}
+
+// GetMaxJobs returns how many jobs are kept
+func GetMaxJobs() int {
+	return maxJobs
+}
+
+// SetJobRetention sets how long finished jobs are kept
+func SetJobRetention(d time.Duration) {
+	jobRetention = d
+}
+
+// GetJobRetention returns how long finished jobs are kept
+func GetJobRetention() time.Duration {
+	return jobRetention
+}
+
+// SetJobOutputLimit sets how many bytes of output are kept per job, in memory
+// or in the jobs directory; older output is dropped
+func SetJobOutputLimit(n int) {
+	jobOutputLimit = n
+}
+
+// GetJobOutputLimit returns how many bytes of output are kept per job
+func GetJobOutputLimit() int {
+	return jobOutputLimit
+}
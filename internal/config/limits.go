@@ -0,0 +1,46 @@
+package config
+
+// Limits caps how many of something may run at the same time, in total, per
+// token and per client IP. 0 means unlimited.
+type Limits struct {
+	Global   int `json:"max"`
+	PerToken int `json:"max_per_token"`
+	PerIP    int `json:"max_per_ip"`
+}
+
+var (
+	sessionLimits   Limits
+	viewerLimits    Limits
+	executionLimits Limits
+)
+
+// SetSessionLimits sets the caps on concurrent terminal sessions
+func SetSessionLimits(limits Limits) {
+	sessionLimits = limits
+}
+
+// GetSessionLimits returns the caps on concurrent terminal sessions
+func GetSessionLimits() Limits {
+	return sessionLimits
+}
+
+// SetViewerLimits sets the caps on concurrent connections joining terminal
+// sessions through share links
+func SetViewerLimits(limits Limits) {
+	viewerLimits = limits
+}
+
+// GetViewerLimits returns the caps on concurrent share link connections
+func GetViewerLimits() Limits {
+	return viewerLimits
+}
+
+// SetExecutionLimits sets the caps on concurrent command executions
+func SetExecutionLimits(limits Limits) {
+	executionLimits = limits
+}
+
+// GetExecutionLimits returns the caps on concurrent command executions
+func GetExecutionLimits() Limits {
+	return executionLimits
+}
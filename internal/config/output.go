@@ -0,0 +1,56 @@
+package config
+
+import (
+	"time"
+)
+
+var (
+	maxOutput       int64 = 10 << 20
+	outputDir       string
+	outputRetention       = 15 * time.Minute
+	outputSpillMax  int64 = 1 << 30
+)
+
+// SetMaxOutput sets how many bytes of output /execute keeps in a response,
+// 0 for no limit. It is the default for requests that do not ask for a limit
+// and the maximum for those that do.
+func SetMaxOutput(n int64) {
+	maxOutput = n
+}
+
+// GetMaxOutput returns how many bytes of output a response keeps, 0 for no limit
+func GetMaxOutput() int64 {
+	return maxOutput
+}
+
+// SetOutputDir sets the directory the full output of truncated commands is
+// written to. An empty directory drops the truncated output.
+func SetOutputDir(dir string) {
+	outputDir = dir
+}
+
+// GetOutputDir returns the directory full output is written to, empty if off
+func GetOutputDir() string {
+	return outputDir
+}
+
+// SetOutputRetention sets how long full output files can be downloaded
+func SetOutputRetention(d time.Duration) {
+	outputRetention = d
+}
+
+// GetOutputRetention returns how long full output files can be downloaded
+func GetOutputRetention() time.Duration {
+	return outputRetention
+}
+
+// SetOutputSpillMax sets how many bytes of a command's full output are written
+// to disk, 0 for no limit
+func SetOutputSpillMax(n int64) {
+	outputSpillMax = n
+}
+
+// GetOutputSpillMax returns how many bytes of full output are written to disk
+func GetOutputSpillMax() int64 {
+	return outputSpillMax
+}
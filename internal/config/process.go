@@ -0,0 +1,18 @@
+package config
+
+import (
+	"time"
+)
+
+var killGracePeriod = 2 * time.Second
+
+// SetKillGracePeriod sets how long processes get to exit after each signal
+// (SIGHUP, then SIGTERM) before they are killed
+func SetKillGracePeriod(d time.Duration) {
+	killGracePeriod = d
+}
+
+// GetKillGracePeriod returns how long processes get to exit after each signal
+func GetKillGracePeriod() time.Duration {
+	return killGracePeriod
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"time"
+)
+
+var (
+	recordingDir      string
+	recordInput       bool
+	recordingMaxAge   time.Duration
+	recordingMaxFiles int
+)
+
+// SetRecordingDir sets the directory terminal recordings are written to.
+// An empty directory disables recording.
+func SetRecordingDir(dir string) {
+	recordingDir = dir
+}
+
+// GetRecordingDir returns the directory terminal recordings are written to
+func GetRecordingDir() string {
+	return recordingDir
+}
+
+// RecordingEnabled checks if terminal sessions are recorded
+func RecordingEnabled() bool {
+	return recordingDir != ""
+}
+
+// SetRecordInput sets whether keystrokes are recorded along with output
+func SetRecordInput(enabled bool) {
+	recordInput = enabled
+}
+
+// GetRecordInput returns whether keystrokes are recorded along with output
+func GetRecordInput() bool {
+	return recordInput
+}
+
+// SetRecordingRetention sets how long and how many recordings are kept.
+// Zero values mean no limit.
+func SetRecordingRetention(maxAge time.Duration, maxFiles int) {
+	recordingMaxAge = maxAge
+	recordingMaxFiles = maxFiles
+}
+
+// GetRecordingRetention returns the maximum age and number of recordings kept
+func GetRecordingRetention() (time.Duration, int) {
+	return recordingMaxAge, recordingMaxFiles
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"time"
+)
+
+var (
+	sessionGracePeriod = 5 * time.Minute
+	legacyProtocol     bool
+	idleTimeout        time.Duration
+	maxSessionDuration time.Duration
+	sessionWarning     = time.Minute
+	pingInterval       = 30 * time.Second
+
+	compressionLevel     = 1
+	compressionThreshold = 256
+)
+
+// SetSessionGracePeriod sets how long a detached terminal session is kept alive
+func SetSessionGracePeriod(d time.Duration) {
+	sessionGracePeriod = d
+}
+
+// GetSessionGracePeriod returns how long a detached terminal session is kept alive
+func GetSessionGracePeriod() time.Duration {
+	return sessionGracePeriod
+}
+
+// SetLegacyProtocol sets whether WebSocket clients may use the original
+// unframed text protocol instead of negotiating the framed one
+func SetLegacyProtocol(enabled bool) {
+	legacyProtocol = enabled
+}
+
+// LegacyProtocolEnabled checks if the unframed text protocol is accepted
+func LegacyProtocolEnabled() bool {
+	return legacyProtocol
+}
+
+// SetIdleTimeout sets how long a terminal session may go without input or
+// output before it is terminated, 0 disables the limit
+func SetIdleTimeout(d time.Duration) {
+	idleTimeout = d
+}
+
+// GetIdleTimeout returns the terminal session idle timeout, 0 if disabled
+func GetIdleTimeout() time.Duration {
+	return idleTimeout
+}
+
+// SetMaxSessionDuration sets how long a terminal session may run in total
+// before it is terminated, 0 disables the limit
+func SetMaxSessionDuration(d time.Duration) {
+	maxSessionDuration = d
+}
+
+// GetMaxSessionDuration returns the maximum terminal session duration, 0 if disabled
+func GetMaxSessionDuration() time.Duration {
+	return maxSessionDuration
+}
+
+// SetSessionWarning sets how long before an idle or maximum duration
+// termination clients are warned
+func SetSessionWarning(d time.Duration) {
+	sessionWarning = d
+}
+
+// GetSessionWarning returns how long before termination clients are warned
+func GetSessionWarning() time.Duration {
+	return sessionWarning
+}
+
+// SetPingInterval sets how often WebSocket clients are pinged, 0 disables
+// pings and dead-peer detection
+func SetPingInterval(d time.Duration) {
+	pingInterval = d
+}
+
+// GetPingInterval returns how often WebSocket clients are pinged
+func GetPingInterval() time.Duration {
+	return pingInterval
+}
+
+// SetCompressionLevel sets the permessage-deflate level for terminal
+// WebSockets, from -2 (Huffman only) to 9 (best compression); 0 disables
+// compression
+func SetCompressionLevel(level int) {
+	compressionLevel = level
+}
+
+// GetCompressionLevel returns the permessage-deflate level, 0 if disabled
+func GetCompressionLevel() int {
+	return compressionLevel
+}
+
+// SetCompressionThreshold sets the frame size below which terminal frames are
+// sent uncompressed
+func SetCompressionThreshold(n int) {
+	compressionThreshold = n
+}
+
+// GetCompressionThreshold returns the frame size below which frames are sent uncompressed
+func GetCompressionThreshold() int {
+	return compressionThreshold
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"os/exec"
+	"path/filepath"
+)
+
+var (
+	shellPath     string
+	shellArgs     []string
+	loginShell    bool
+	shellDir      string
+	shellEnv      []string
+	allowedShells []string
+)
+
+// SetShell sets the shell binary and arguments used for terminal sessions.
+// An empty path picks bash if installed, or /bin/sh otherwise.
+func SetShell(path string, args []string) {
+	shellPath = path
+	shellArgs = args
+}
+
+// GetShell returns the shell binary and arguments used for terminal sessions
+func GetShell() (string, []string) {
+	if shellPath == "" {
+		if _, err := exec.LookPath("bash"); err == nil {
+			return "bash", shellArgs
+		}
+		return "/bin/sh", shellArgs
+	}
+	return shellPath, shellArgs
+}
+
+// SetLoginShell sets whether terminal shells are started as login shells
+func SetLoginShell(enabled bool) {
+	loginShell = enabled
+}
+
+// GetLoginShell returns whether terminal shells are started as login shells
+func GetLoginShell() bool {
+	return loginShell
+}
+
+// SetShellDir sets the initial working directory of terminal shells
+func SetShellDir(dir string) {
+	shellDir = dir
+}
+
+// GetShellDir returns the initial working directory of terminal shells
+func GetShellDir() string {
+	return shellDir
+}
+
+// SetShellEnv sets extra KEY=VALUE environment variables for terminal shells
+func SetShellEnv(env []string) {
+	shellEnv = env
+}
+
+// GetShellEnv returns extra KEY=VALUE environment variables for terminal shells
+func GetShellEnv() []string {
+	return shellEnv
+}
+
+// SetAllowedShells sets the shells clients may request in addition to the
+// configured default
+func SetAllowedShells(shells []string) {
+	allowedShells = shells
+}
+
+// GetAllowedShells returns the shells clients may request, default first
+func GetAllowedShells() []string {
+	shell, _ := GetShell()
+	return append([]string{shell}, allowedShells...)
+}
+
+// IsShellAllowed checks if a client may start the given shell binary.
+// Names are resolved through PATH so "zsh" and "/bin/zsh" match each other.
+func IsShellAllowed(path string) bool {
+	requested := resolveBinary(path)
+	for _, allowed := range GetAllowedShells() {
+		if resolveBinary(allowed) == requested {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveBinary returns the cleaned absolute path of a binary name
+func resolveBinary(name string) string {
+	if resolved, err := exec.LookPath(name); err == nil {
+		name = resolved
+	}
+	if abs, err := filepath.Abs(name); err == nil {
+		name = abs
+	}
+	if real, err := filepath.EvalSymlinks(name); err == nil {
+		name = real
+	}
+	return filepath.Clean(name)
+}
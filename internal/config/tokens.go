@@ -0,0 +1,75 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Token is an additional named authentication token loaded from the tokens file
+type Token struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	// RunAs is a "user[:group]" spec processes started with this token run as
+	RunAs string `json:"run_as,omitempty"`
+	// Roles are matched by command policy rules
+	Roles []string `json:"roles,omitempty"`
+}
+
+var (
+	tokens       []Token
+	defaultRunAs string
+)
+
+// LoadTokensFile reads named tokens from a JSON file containing an array of tokens
+func LoadTokensFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var loaded []Token
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("invalid tokens file %s: %w", path, err)
+	}
+
+	seen := make(map[string]bool)
+	for i, t := range loaded {
+		if t.Name == "" || t.Token == "" {
+			return fmt.Errorf("invalid tokens file %s: entry %d needs a name and a token", path, i)
+		}
+		if seen[t.Name] {
+			return fmt.Errorf("invalid tokens file %s: duplicate name %q", path, t.Name)
+		}
+		seen[t.Name] = true
+	}
+
+	tokens = loaded
+	return nil
+}
+
+// GetTokens returns the named tokens
+func GetTokens() []Token {
+	return tokens
+}
+
+// LookupToken finds the named token matching value
+func LookupToken(value string) (Token, bool) {
+	for _, t := range tokens {
+		if t.Token == value {
+			return t, true
+		}
+	}
+	return Token{}, false
+}
+
+// SetRunAs sets the "user[:group]" spec processes run as unless a token
+// overrides it. Empty means the server's own user.
+func SetRunAs(spec string) {
+	defaultRunAs = spec
+}
+
+// GetRunAs returns the default "user[:group]" spec processes run as
+func GetRunAs() string {
+	return defaultRunAs
+}
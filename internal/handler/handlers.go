@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,8 +12,12 @@ import (
 	"strings"
 	"time"
 
+	"github.com/adaptive-scale/webshell/internal/auth"
 	"github.com/adaptive-scale/webshell/internal/commands"
 	"github.com/adaptive-scale/webshell/internal/config"
+	"github.com/adaptive-scale/webshell/internal/limits"
+	"github.com/adaptive-scale/webshell/internal/policy"
+	"github.com/adaptive-scale/webshell/internal/process"
 	"github.com/adaptive-scale/webshell/internal/templates"
 )
 
@@ -30,11 +35,13 @@ func Home(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// No command whitelist - all commands are allowed
+	// List the commands the policy allows everyone to run
 	data := struct {
 		AllowedCommands []string
+		PolicyEnabled   bool
 	}{
-		AllowedCommands: []string{}, // Empty list - all commands are allowed
+		AllowedCommands: policy.AllowedCommands(),
+		PolicyEnabled:   policy.Enabled(),
 	}
 
 	w.Header().Set("Content-Type", "text/html")
@@ -52,79 +59,149 @@ func ExecuteCommand(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Read raw body
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
-		return
-	}
 	defer r.Body.Close()
 
-	// Parse command from raw body
-	commandLine := strings.TrimSpace(string(body))
-	if commandLine == "" {
-		http.Error(w, "Command is required", http.StatusBadRequest)
+	// Parse command from the query, a multipart form, a JSON request or the
+	// raw body
+	req, err := parseExecuteRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	opts, script := req.opts, req.script
 
 	// Check if JSON response is requested
 	acceptHeader := r.Header.Get("Accept")
 	wantJSON := acceptHeader == "application/json"
 
-	// Execute command directly without whitelist restriction
-	// Support both single commands and full scripts
-	// If the command line contains newlines, treat it as a script
-	if strings.Contains(commandLine, "\n") {
-		// Execute as bash script
-		response := commands.ExecuteCommand("bash", []string{"-c", commandLine})
-		// Return response based on Accept header
-		if wantJSON {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusOK)
-			json.NewEncoder(w).Encode(response)
-		} else {
-			// Return raw output
-			w.Header().Set("Content-Type", "text/plain")
-			w.WriteHeader(http.StatusOK)
-			if response.Success {
-				w.Write([]byte(response.Output))
-			} else {
-				w.Write([]byte("Error: " + response.Error + "\n" + response.Output))
-			}
-		}
+	// Resolve the user the command runs as
+	runAs, err := process.LookupUser(auth.RunAs(r))
+	if err != nil {
+		http.Error(w, "Failed to resolve run-as user", http.StatusInternalServerError)
+		log.Printf("Failed to resolve run-as user: %v", err)
+		return
+	}
+	opts.RunAs = runAs
+	if err := checkDir(opts); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Split command into command and arguments for simple commands
-	parts := strings.Fields(commandLine)
-	if len(parts) == 0 {
-		http.Error(w, "Invalid command", http.StatusBadRequest)
+	// Refuse commands the policy denies
+	if !checkPolicy(w, r, policy.SourceExecute, opts, wantJSON) {
+		return
+	}
+
+	// Take an execution slot, waiting for one if queuing is enabled
+	release, err := acquireExecution(r)
+	if err != nil {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
 		return
 	}
+	defer release()
 
-	command := parts[0]
-	args := parts[1:]
+	opts.Owner = auth.Principal(r)
+	opts.Interleaved = r.URL.Query().Get("interleaved") == "true"
 
-	// Execute command (no whitelist restriction)
-	response := commands.ExecuteCommand(command, args)
+	// Output past the limit is dropped, keeping its head, tail or both
+	if err := parseOutputLimit(r, &opts); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Raw text responses return the combined output or one stream
+	stream := r.URL.Query().Get("output")
+	if stream != "" && stream != "combined" && stream != "stdout" && stream != "stderr" {
+		http.Error(w, "Invalid output, use combined, stdout or stderr", http.StatusBadRequest)
+		return
+	}
+
+	// Failed commands answer 200 unless the request or server asks for an
+	// error status
+	fail, err := failStatus(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Stream output as it arrives if requested. Once a streamed response
+	// has started, HTTP/1.x gives no guarantee the rest of the request body
+	// can still be read, so stdin from the body needs a buffered response.
+	mode := streamMode(r)
+	if req.bodyStdin && mode != streamNone {
+		http.Error(w, "Stdin from the request body cannot be combined with a streamed response, send it in a JSON request instead", http.StatusBadRequest)
+		return
+	}
+	switch mode {
+	case streamEvents:
+		streamEventsResponse(w, r, opts)
+		return
+	case streamRaw:
+		streamRawResponse(w, r, opts, stream)
+		return
+	}
+
+	// Execute command
+	response := commands.ExecuteCommand(opts)
 
 	// Return response based on Accept header
+	if response.FullOutput != nil {
+		response.FullOutput.URL = "output/" + response.FullOutput.ID
+	}
+	status := responseStatus(response, fail)
+	setResultHeaders(w, response)
+	setOutputHeaders(w, response)
 	if wantJSON {
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
+		w.WriteHeader(status)
 		json.NewEncoder(w).Encode(response)
 	} else {
 		// Return raw output
+		output := response.Output
+		switch stream {
+		case "stdout":
+			output = response.Stdout
+		case "stderr":
+			output = response.Stderr
+		}
 		w.Header().Set("Content-Type", "text/plain")
-		w.WriteHeader(http.StatusOK)
+		w.WriteHeader(status)
 		if response.Success {
-			w.Write([]byte(response.Output))
+			w.Write([]byte(output))
+		} else if script {
+			w.Write([]byte("Error: " + response.Error + "\n" + output))
 		} else {
 			w.Write([]byte("Error: " + response.Error))
 		}
 	}
 }
 
+// parseCommandLine turns a request body into a command and its arguments.
+// Both single commands and full scripts are supported: a command line with
+// newlines is run as a bash script, anything else is split on whitespace.
+func parseCommandLine(commandLine string) (string, []string, bool) {
+	if strings.Contains(commandLine, "\n") {
+		return "bash", []string{"-c", commandLine}, true
+	}
+	parts := strings.Fields(commandLine)
+	return parts[0], parts[1:], false
+}
+
+// acquireExecution takes a slot in limits.Executions for the client making r.
+// With a queue timeout configured it waits up to that long for a slot to be
+// released, otherwise it fails immediately when a limit is reached.
+func acquireExecution(r *http.Request) (func(), error) {
+	token, ip := auth.Principal(r), auth.ClientIP(r)
+	timeout := config.GetExecuteQueueTimeout()
+	if timeout <= 0 {
+		return limits.Executions.TryAcquire(token, ip)
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	return limits.Executions.Acquire(ctx, token, ip)
+}
+
 // handleHealth serves the health check endpoint
 func Health(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -136,6 +213,11 @@ func Health(w http.ResponseWriter, r *http.Request) {
 		"status":    "healthy",
 		"timestamp": time.Now().UTC().Format(time.RFC3339),
 		"uptime":    "running",
+		"limits": map[string]limits.Usage{
+			"sessions":   limits.Sessions.Usage(),
+			"viewers":    limits.Viewers.Usage(),
+			"executions": limits.Executions.Usage(),
+		},
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -202,38 +284,57 @@ func UploadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Refuse paths the policy denies
+	if !checkFilePolicy(w, r, policy.SourceUpload, targetPath) {
+		return
+	}
+
 	// Get overwrite option (default: skip)
 	overwrite := r.FormValue("overwrite") == "true"
 
+	// Files are written with the permissions of the run-as user
+	runAs, err := process.LookupUser(auth.RunAs(r))
+	if err != nil {
+		http.Error(w, "Failed to resolve run-as user", http.StatusInternalServerError)
+		log.Printf("Failed to resolve run-as user: %v", err)
+		return
+	}
+
 	// Check if file exists
 	fileExisted := false
-	if _, err := os.Stat(targetPath); err == nil {
-		fileExisted = true
-		if !overwrite {
-			// File exists and overwrite is false, skip
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusOK)
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"status":  "skipped",
-				"message": "File already exists, skipped",
-				"path":    targetPath,
-			})
-			return
-		}
+	process.AsUser(runAs, func() error {
+		_, err := os.Stat(targetPath)
+		fileExisted = err == nil
+		return nil
+	})
+	if fileExisted && !overwrite {
+		// File exists and overwrite is false, skip
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "skipped",
+			"message": "File already exists, skipped",
+			"path":    targetPath,
+		})
+		return
 	}
 
 	// Create directory if it doesn't exist
 	dir := filepath.Dir(targetPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create directory: %v", err), http.StatusInternalServerError)
+	if err := process.AsUser(runAs, func() error { return os.MkdirAll(dir, 0755) }); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create directory: %v", err), fileErrorStatus(err))
 		log.Printf("Failed to create directory %s: %v", dir, err)
 		return
 	}
 
 	// Create or overwrite the file
-	dst, err := os.Create(targetPath)
+	var dst *os.File
+	err = process.AsUser(runAs, func() (err error) {
+		dst, err = os.Create(targetPath)
+		return err
+	})
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create file: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Failed to create file: %v", err), fileErrorStatus(err))
 		log.Printf("Failed to create file %s: %v", targetPath, err)
 		return
 	}
@@ -274,30 +375,46 @@ func DownloadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if file exists
-	info, err := os.Stat(filePath)
+	// Refuse paths the policy denies
+	if !checkFilePolicy(w, r, policy.SourceDownload, filePath) {
+		return
+	}
+
+	// Files are read with the permissions of the run-as user
+	runAs, err := process.LookupUser(auth.RunAs(r))
+	if err != nil {
+		http.Error(w, "Failed to resolve run-as user", http.StatusInternalServerError)
+		log.Printf("Failed to resolve run-as user: %v", err)
+		return
+	}
+
+	// Open the file and check it exists
+	var file *os.File
+	err = process.AsUser(runAs, func() (err error) {
+		file, err = os.Open(filePath)
+		return err
+	})
 	if err != nil {
 		if os.IsNotExist(err) {
 			http.Error(w, "File not found", http.StatusNotFound)
 		} else {
-			http.Error(w, fmt.Sprintf("Failed to access file: %v", err), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Failed to open file: %v", err), fileErrorStatus(err))
 		}
 		return
 	}
+	defer file.Close()
 
-	// Check if it's a directory
-	if info.IsDir() {
-		http.Error(w, "Path is a directory, not a file", http.StatusBadRequest)
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to access file: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// Open the file
-	file, err := os.Open(filePath)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to open file: %v", err), http.StatusInternalServerError)
+	// Check if it's a directory
+	if info.IsDir() {
+		http.Error(w, "Path is a directory, not a file", http.StatusBadRequest)
 		return
 	}
-	defer file.Close()
 
 	// Set headers for file download
 	filename := filepath.Base(filePath)
@@ -312,3 +429,12 @@ func DownloadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// fileErrorStatus returns 403 for errors caused by file permissions and 500
+// for anything else
+func fileErrorStatus(err error) int {
+	if os.IsPermission(err) {
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
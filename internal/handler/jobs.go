@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/adaptive-scale/webshell/internal/auth"
+	"github.com/adaptive-scale/webshell/internal/jobs"
+	"github.com/adaptive-scale/webshell/internal/limits"
+	"github.com/adaptive-scale/webshell/internal/policy"
+	"github.com/adaptive-scale/webshell/internal/process"
+)
+
+const (
+	// defaultOutputChunk and maxOutputChunk bound how much output one
+	// GET /jobs/<id>/output returns
+	defaultOutputChunk = 1 << 20
+	maxOutputChunk     = 16 << 20
+	// maxOutputWait bounds how long a client may wait for new output
+	maxOutputWait = 60 * time.Second
+)
+
+// Jobs starts a job (POST) or lists the jobs of the requesting token, or all
+// jobs for admins (GET). A job is started from a JSON request, raw command
+// line or script body, like /execute.
+func Jobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		list := []jobs.Info{}
+		for _, info := range jobs.List() {
+			if auth.CanAccess(r, info.Owner) {
+				list = append(list, info)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"count": len(list),
+			"jobs":  list,
+		})
+
+	case http.MethodPost:
+		startJob(w, r)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// startJob starts the command in the request body in the background and
+// answers with the new job
+func startJob(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	req, err := parseExecuteRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The request is answered right away, so its body cannot feed the job
+	if req.bodyStdin {
+		http.Error(w, "Jobs take stdin from a JSON request only", http.StatusBadRequest)
+		return
+	}
+	opts := req.opts
+
+	// Refuse commands the policy denies
+	if !checkPolicy(w, r, policy.SourceJobs, opts, true) {
+		return
+	}
+
+	// Resolve the user the command runs as
+	opts.RunAs, err = process.LookupUser(auth.RunAs(r))
+	if err != nil {
+		http.Error(w, "Failed to resolve run-as user", http.StatusInternalServerError)
+		log.Printf("Failed to resolve run-as user: %v", err)
+		return
+	}
+	if err := checkDir(opts); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	info, err := jobs.Start(auth.Principal(r), auth.ClientIP(r), opts)
+	var limitErr *limits.LimitError
+	switch {
+	case err == nil:
+	case errors.As(err, &limitErr), errors.Is(err, jobs.ErrStoreFull):
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	default:
+		log.Printf("Failed to start job: %v", err)
+		http.Error(w, "Failed to start job", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "jobs/"+info.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(info)
+}
+
+// Job inspects (GET) or cancels (DELETE) a single job and serves its output
+// under <id>/output. Jobs of other tokens are reported as not found unless
+// the request is from an admin.
+func Job(w http.ResponseWriter, r *http.Request) {
+	id, action := pathID(r, "output")
+	if id == "" {
+		Jobs(w, r)
+		return
+	}
+
+	info, err := jobs.Get(id)
+	if err != nil || !auth.CanAccess(r, info.Owner) {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if action == "output" {
+		jobOutput(w, r, id)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(info)
+
+	case http.MethodDelete:
+		info, removed, err := jobs.Cancel(id)
+		if err != nil {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		}
+		status, message := "canceling", "Job is being terminated"
+		if removed {
+			status, message = "removed", "Job and its output removed"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  status,
+			"message": message,
+			"job":     info,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// jobOutput serves a job's output from the offset query parameter, up to
+// limit bytes. With wait set it holds the request until there is output past
+// the offset or the job finishes, so clients can tail a job by passing the
+// returned X-Next-Offset back. The offset actually served is returned in
+// X-Output-Offset; it is past the requested one if that output was dropped.
+func jobOutput(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	var offset int64
+	var err error
+	if value := query.Get("offset"); value != "" {
+		offset, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || offset < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+	limit := defaultOutputChunk
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		if limit > maxOutputChunk {
+			limit = maxOutputChunk
+		}
+	}
+	var wait time.Duration
+	if value := query.Get("wait"); value != "" {
+		wait, err = time.ParseDuration(value)
+		if err != nil || wait < 0 {
+			http.Error(w, "Invalid wait duration", http.StatusBadRequest)
+			return
+		}
+		if wait > maxOutputWait {
+			wait = maxOutputWait
+		}
+	}
+
+	data, start, info, err := jobs.Output(r.Context(), id, offset, limit, wait)
+	if errors.Is(err, jobs.ErrNotFound) {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to read output of job %s: %v", id, err)
+		http.Error(w, "Failed to read job output", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("X-Job-State", info.State)
+	w.Header().Set("X-Output-Offset", strconv.FormatInt(start, 10))
+	w.Header().Set("X-Next-Offset", strconv.FormatInt(start+int64(len(data)), 10))
+	if info.ExitCode != nil {
+		w.Header().Set("X-Exit-Code", strconv.Itoa(*info.ExitCode))
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/adaptive-scale/webshell/internal/auth"
+	"github.com/adaptive-scale/webshell/internal/commands"
+	"github.com/adaptive-scale/webshell/internal/size"
+	"github.com/adaptive-scale/webshell/internal/spill"
+)
+
+// parseOutputLimit applies the max_output (bytes, or with a K, M or G suffix)
+// and keep (head, tail or both) query parameters to opts
+func parseOutputLimit(r *http.Request, opts *commands.Options) error {
+	query := r.URL.Query()
+	if value := query.Get("max_output"); value != "" {
+		n, err := size.Parse(value)
+		if err != nil {
+			return errors.New("Invalid max_output, use bytes or a K, M or G suffix")
+		}
+		opts.MaxOutput = n
+	}
+	switch keep := query.Get("keep"); keep {
+	case "", commands.KeepHead, commands.KeepTail, commands.KeepBoth:
+		opts.Keep = keep
+	default:
+		return errors.New("Invalid keep, use head, tail or both")
+	}
+	return nil
+}
+
+// setOutputHeaders reports truncated output in the X-Output-Truncated,
+// X-Dropped-Bytes and X-Full-Output headers
+func setOutputHeaders(w http.ResponseWriter, response commands.CommandResponse) {
+	if !response.Truncated {
+		return
+	}
+	w.Header().Set("X-Output-Truncated", "true")
+	w.Header().Set("X-Dropped-Bytes", strconv.FormatInt(response.DroppedBytes, 10))
+	if response.FullOutput != nil {
+		w.Header().Set("X-Full-Output", response.FullOutput.URL)
+	}
+}
+
+// Output serves the full output of a truncated command by its ID. Output of
+// other tokens' commands is reported as not found unless the request is from
+// an admin.
+func Output(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, _ := pathID(r)
+	file, owner, err := spill.Open(id)
+	if err == nil && !auth.CanAccess(r, owner) {
+		file.Close()
+		err = spill.ErrNotFound
+	}
+	if errors.Is(err, spill.ErrNotFound) {
+		http.Error(w, "Output not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to open output %s: %v", id, err)
+		http.Error(w, "Failed to read output", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		log.Printf("Failed to read output %s: %v", id, err)
+		http.Error(w, "Failed to read output", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", info.ModTime(), file)
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/adaptive-scale/webshell/internal/auth"
+	"github.com/adaptive-scale/webshell/internal/commands"
+	"github.com/adaptive-scale/webshell/internal/policy"
+)
+
+// evaluatePolicy evaluates the command opts describes for the client making r
+func evaluatePolicy(r *http.Request, source string, opts commands.Options) policy.Decision {
+	return policy.Evaluate(policy.Request{
+		Source:  source,
+		Token:   auth.TokenName(r),
+		Roles:   auth.Roles(r),
+		Command: opts.Command,
+		Args:    opts.Args,
+		Dir:     opts.Dir,
+		Env:     opts.Env,
+	})
+}
+
+// checkPolicy tells whether the policy allows the command. A denied command
+// is answered with 403 and the reason, as JSON if wantJSON is set.
+func checkPolicy(w http.ResponseWriter, r *http.Request, source string, opts commands.Options, wantJSON bool) bool {
+	decision := evaluatePolicy(r, source, opts)
+	if decision.Allowed {
+		return true
+	}
+
+	log.Printf("Denied %s command %q for %s: %s", source, opts.Command, auth.Principal(r), decision.Reason)
+	if !wantJSON {
+		http.Error(w, "Denied by policy: "+decision.Reason, http.StatusForbidden)
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  "Denied by policy",
+		"policy": decision,
+	})
+	return false
+}
+
+// checkFilePolicy tells whether the policy allows uploading or downloading
+// path. A denied transfer is answered with 403 and the reason.
+func checkFilePolicy(w http.ResponseWriter, r *http.Request, source, path string) bool {
+	decision := evaluateFilePolicy(r, source, path)
+	if decision.Allowed {
+		return true
+	}
+
+	log.Printf("Denied %s of %q for %s: %s", source, path, auth.Principal(r), decision.Reason)
+	http.Error(w, "Denied by policy: "+decision.Reason, http.StatusForbidden)
+	return false
+}
+
+// evaluateFilePolicy evaluates an upload or download of path for the client
+// making r
+func evaluateFilePolicy(r *http.Request, source, path string) policy.Decision {
+	return policy.Evaluate(policy.Request{
+		Source: source,
+		Token:  auth.TokenName(r),
+		Roles:  auth.Roles(r),
+		Path:   path,
+	})
+}
+
+// PolicyEvaluate evaluates a command against the policy without running it.
+// The command is described like for /execute; ?source= picks execute (the
+// default), jobs or terminal. Terminal requests are evaluated as the shell
+// binary with its arguments. With ?source=upload or download the file given
+// by ?path= is evaluated instead.
+func PolicyEvaluate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	defer r.Body.Close()
+
+	source := r.URL.Query().Get("source")
+	switch source {
+	case "":
+		source = policy.SourceExecute
+	case policy.SourceExecute, policy.SourceJobs, policy.SourceTerminal:
+	case policy.SourceUpload, policy.SourceDownload:
+		path := r.URL.Query().Get("path")
+		if path == "" {
+			http.Error(w, "File path is required", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(evaluateFilePolicy(r, source, path))
+		return
+	default:
+		http.Error(w, "Invalid source, use execute, jobs, terminal, upload or download", http.StatusBadRequest)
+		return
+	}
+
+	req, err := parseExecuteRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(evaluatePolicy(r, source, req.opts))
+}
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/adaptive-scale/webshell/internal/auth"
+	"github.com/adaptive-scale/webshell/internal/config"
+	"github.com/adaptive-scale/webshell/internal/recording"
+	"github.com/adaptive-scale/webshell/internal/templates"
+)
+
+// Recordings lists the stored recordings of the requesting token's sessions,
+// or all recordings for admins
+func Recordings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !config.RecordingEnabled() {
+		http.Error(w, "Recording is disabled", http.StatusNotFound)
+		return
+	}
+
+	all, err := recording.List()
+	if err != nil {
+		http.Error(w, "Failed to list recordings", http.StatusInternalServerError)
+		log.Printf("Failed to list recordings: %v", err)
+		return
+	}
+	recordings := []recording.Info{}
+	for _, info := range all {
+		if auth.CanAccess(r, info.Owner) {
+			recordings = append(recordings, info)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"count":      len(recordings),
+		"recordings": recordings,
+	})
+}
+
+// Recording downloads a single recording as an asciicast v2 file, or serves
+// the playback page for <id>/play. Recordings of other tokens' sessions, and
+// recordings without an owner, are reported as not found unless the request
+// is from an admin.
+func Recording(w http.ResponseWriter, r *http.Request) {
+	id, action := pathID(r, "play")
+	if id == "" {
+		Recordings(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !config.RecordingEnabled() {
+		http.Error(w, "Recording is disabled", http.StatusNotFound)
+		return
+	}
+
+	id = strings.TrimSuffix(id, recording.Extension)
+	rec, err := recording.Get(id)
+	if err == nil && !auth.CanAccess(r, rec.Owner) {
+		err = recording.ErrNotFound
+	}
+	if err != nil {
+		if err == recording.ErrNotFound {
+			http.Error(w, "Recording not found", http.StatusNotFound)
+		} else {
+			http.Error(w, fmt.Sprintf("Failed to access recording: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if action == "play" {
+		playRecording(w, id)
+		return
+	}
+
+	file, err := recording.Open(id)
+	if err != nil {
+		if err == recording.ErrNotFound {
+			http.Error(w, "Recording not found", http.StatusNotFound)
+		} else {
+			http.Error(w, fmt.Sprintf("Failed to open recording: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to access recording: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s%s", id, recording.Extension))
+	http.ServeContent(w, r, id+recording.Extension, info.ModTime(), file)
+}
+
+// playRecording serves the playback page for a recording
+func playRecording(w http.ResponseWriter, id string) {
+	tmpl, err := templates.GetPlaybackTemplate()
+	if err != nil {
+		http.Error(w, "Failed to load template", http.StatusInternalServerError)
+		log.Printf("Failed to get playback template: %v", err)
+		return
+	}
+
+	data := struct {
+		ID string
+	}{
+		ID: id,
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusOK)
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		log.Printf("Failed to execute template: %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adaptive-scale/webshell/internal/commands"
+	"github.com/adaptive-scale/webshell/internal/process"
+)
+
+// errBodyRead is returned when the request body cannot be read
+var errBodyRead = errors.New("Failed to read request body")
+
+// executeRequest is the JSON body accepted by /execute and /jobs
+type executeRequest struct {
+	// Argv is the command and its arguments, passed as is without splitting
+	// or quoting
+	Argv []string `json:"argv"`
+	// Stdin is written to the command's standard input
+	Stdin *string `json:"stdin"`
+	// Env holds extra environment variables
+	Env map[string]string `json:"env"`
+	// Cwd is the working directory
+	Cwd string `json:"cwd"`
+	// Timeout is a duration such as "30s", capped at the server's timeout
+	Timeout string `json:"timeout"`
+	// Shell runs Argv[0] as a bash script, with the remaining elements as its
+	// positional parameters $1, $2, ...
+	Shell bool `json:"shell"`
+}
+
+// parsedRequest is a command to execute as described by a request
+type parsedRequest struct {
+	opts commands.Options
+	// script tells whether the command runs as a bash script
+	script bool
+	// bodyStdin is set when stdin is the rest of the request body, which is
+	// read while the command runs
+	bodyStdin bool
+}
+
+// mediaType returns the media type of the request body
+func mediaType(r *http.Request) string {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// parseExecuteRequest builds the command options from a request. The command
+// is described by one of:
+//   - ?argv= parameters, ?command= or an X-Command header, with the body as stdin
+//   - a multipart form with a "command" or "request" part, optionally followed
+//     by a "stdin" part that must come last
+//   - a JSON executeRequest body
+//   - a raw command line or script body
+//
+// Stdin from the body is streamed into the command, not read here. The caller
+// sets RunAs.
+func parseExecuteRequest(r *http.Request) (parsedRequest, error) {
+	query := r.URL.Query()
+	if argv := query["argv"]; len(argv) > 0 {
+		if argv[0] == "" {
+			return parsedRequest{}, errors.New("argv is required")
+		}
+		opts := commands.Options{Command: argv[0], Args: argv[1:], Stdin: r.Body}
+		return parsedRequest{opts: opts, bodyStdin: true}, nil
+	}
+	commandLine := query.Get("command")
+	if commandLine == "" {
+		commandLine = r.Header.Get("X-Command")
+	}
+	if commandLine != "" {
+		req, err := parseRawRequest([]byte(commandLine))
+		req.opts.Stdin, req.bodyStdin = r.Body, true
+		return req, err
+	}
+
+	switch mediaType(r) {
+	case "multipart/form-data":
+		return parseMultipartRequest(r)
+	case "application/json":
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return parsedRequest{}, errBodyRead
+		}
+		return parseJSONRequest(body)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return parsedRequest{}, errBodyRead
+	}
+	return parseRawRequest(body)
+}
+
+// parseRawRequest parses a raw command line or script
+func parseRawRequest(body []byte) (parsedRequest, error) {
+	commandLine := strings.TrimSpace(string(body))
+	if commandLine == "" {
+		return parsedRequest{}, errors.New("Command is required")
+	}
+	command, args, script := parseCommandLine(commandLine)
+	return parsedRequest{opts: commands.Options{Command: command, Args: args}, script: script}, nil
+}
+
+// parseMultipartRequest reads the command from the "command" (raw command
+// line) or "request" (JSON) part of a multipart form. A "stdin" part after it
+// becomes the command's stdin and is left unread, so it must be the last part.
+func parseMultipartRequest(r *http.Request) (parsedRequest, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return parsedRequest{}, fmt.Errorf("Invalid multipart request: %v", err)
+	}
+
+	var req parsedRequest
+	var found bool
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return parsedRequest{}, fmt.Errorf("Invalid multipart request: %v", err)
+		}
+
+		switch part.FormName() {
+		case "command", "request":
+			if found {
+				return parsedRequest{}, errors.New("Only one command or request part is allowed")
+			}
+			data, err := io.ReadAll(part)
+			if err != nil {
+				return parsedRequest{}, errBodyRead
+			}
+			if part.FormName() == "command" {
+				req, err = parseRawRequest(data)
+			} else {
+				req, err = parseJSONRequest(data)
+			}
+			if err != nil {
+				return parsedRequest{}, err
+			}
+			found = true
+		case "stdin":
+			if !found {
+				return parsedRequest{}, errors.New("The stdin part must come after the command or request part")
+			}
+			if req.opts.Stdin != nil {
+				return parsedRequest{}, errors.New("Stdin is given in both the request and a stdin part")
+			}
+			req.opts.Stdin, req.bodyStdin = part, true
+			return req, nil
+		default:
+			return parsedRequest{}, fmt.Errorf("Unexpected multipart part %q", part.FormName())
+		}
+	}
+	if !found {
+		return parsedRequest{}, errors.New("Command is required")
+	}
+	return req, nil
+}
+
+// parseJSONRequest parses a JSON executeRequest
+func parseJSONRequest(body []byte) (parsedRequest, error) {
+	var req executeRequest
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return parsedRequest{}, fmt.Errorf("Invalid JSON request: %v", err)
+	}
+	if len(req.Argv) == 0 || req.Argv[0] == "" {
+		return parsedRequest{}, errors.New("argv is required")
+	}
+
+	opts := commands.Options{Command: req.Argv[0], Args: req.Argv[1:], Dir: req.Cwd}
+	if req.Shell {
+		opts.Command = "bash"
+		opts.Args = append([]string{"-c", req.Argv[0], "bash"}, req.Argv[1:]...)
+	}
+	if req.Stdin != nil {
+		opts.Stdin = strings.NewReader(*req.Stdin)
+	}
+	keys := make([]string, 0, len(req.Env))
+	for key := range req.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := req.Env[key]
+		if key == "" || strings.ContainsAny(key, "=\x00") || strings.Contains(value, "\x00") {
+			return parsedRequest{}, fmt.Errorf("Invalid environment variable %q", key)
+		}
+		opts.Env = append(opts.Env, key+"="+value)
+	}
+	if req.Timeout != "" {
+		timeout, err := time.ParseDuration(req.Timeout)
+		if err != nil || timeout <= 0 {
+			return parsedRequest{}, fmt.Errorf("Invalid timeout %q", req.Timeout)
+		}
+		opts.Timeout = timeout
+	}
+	return parsedRequest{opts: opts, script: req.Shell}, nil
+}
+
+// checkDir fails unless opts.Dir is empty or a directory opts.RunAs can
+// enter. exec reports a missing directory as a missing command, so it is
+// checked before the command starts.
+func checkDir(opts commands.Options) error {
+	if opts.Dir == "" {
+		return nil
+	}
+	err := process.AsUser(opts.RunAs, func() error {
+		// Through "." so that search permission on the directory is needed too
+		_, err := os.Stat(opts.Dir + "/.")
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Invalid cwd %q: not a directory the run-as user can enter", opts.Dir)
+	}
+	return nil
+}
+
+// pathID splits the request path into a resource ID and the action named
+// after it, one of actions, e.g. "abc/share" into "abc" and "share". The path
+// must start at the ID, so handlers using it are mounted behind
+// http.StripPrefix.
+func pathID(r *http.Request, actions ...string) (id, action string) {
+	id = strings.Trim(r.URL.Path, "/")
+	for _, a := range actions {
+		if strings.HasSuffix(id, "/"+a) {
+			return strings.TrimSuffix(id, "/"+a), a
+		}
+	}
+	return id, ""
+}
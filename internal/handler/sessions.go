@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/adaptive-scale/webshell/internal/auth"
+	"github.com/adaptive-scale/webshell/internal/terminal"
+)
+
+// Sessions lists the live terminal sessions the request may access: its own,
+// or every session for admins
+func Sessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessions := []terminal.SessionInfo{}
+	for _, info := range terminal.ListSessions() {
+		if auth.CanAccess(r, info.Owner) {
+			sessions = append(sessions, info)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"count":    len(sessions),
+		"sessions": sessions,
+	})
+}
+
+// Session inspects (GET) or terminates (DELETE) a single terminal session,
+// and manages its share links under <id>/share. Sessions of other tokens are
+// reported as not found unless the request is from an admin.
+func Session(w http.ResponseWriter, r *http.Request) {
+	id, action := pathID(r, "share")
+	if id == "" {
+		Sessions(w, r)
+		return
+	}
+
+	info, ok := terminal.GetSession(id)
+	if !ok || !auth.CanAccess(r, info.Owner) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if action == "share" {
+		shareSession(w, r, id)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(info)
+
+	case http.MethodDelete:
+		reason := "session terminated by its owner"
+		if info.Owner != auth.Principal(r) {
+			reason = "session terminated by an administrator"
+		}
+		if !terminal.KillSession(id, reason) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "terminated",
+			"message": "Session terminated",
+			"id":      id,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// shareSession creates (POST) or revokes (DELETE) share links for a session
+func shareSession(w http.ResponseWriter, r *http.Request, id string) {
+	switch r.Method {
+	case http.MethodPost:
+		// Mode comes from a JSON body or the mode query parameter
+		var req struct {
+			Mode string `json:"mode"`
+		}
+		if r.Body != nil {
+			json.NewDecoder(r.Body).Decode(&req)
+		}
+		if req.Mode == "" {
+			req.Mode = r.URL.Query().Get("mode")
+		}
+		if req.Mode == "" {
+			req.Mode = terminal.ModeReadOnly
+		}
+
+		key, err := terminal.ShareSession(id, req.Mode)
+		switch err {
+		case nil:
+		case terminal.ErrSessionNotFound:
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		case terminal.ErrInvalidShareMode:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		default:
+			http.Error(w, "Failed to share session", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":   id,
+			"key":  key,
+			"mode": req.Mode,
+			"path": "terminal?share=" + key,
+		})
+
+	case http.MethodDelete:
+		if err := terminal.RevokeShares(id); err != nil {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "revoked",
+			"message": "Share links revoked",
+			"id":      id,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/adaptive-scale/webshell/internal/commands"
+	"github.com/adaptive-scale/webshell/internal/config"
+)
+
+// defaultFailStatus is used when a request asks for failure statuses and the
+// server has none configured
+const defaultFailStatus = http.StatusUnprocessableEntity
+
+// failStatus returns the status a request wants for commands that exit
+// non-zero, or 0 to always answer 200. The fail query parameter is true,
+// false or a status code from 400 to 599; without it the server's
+// configured status applies.
+func failStatus(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("fail")
+	switch value {
+	case "":
+		return config.GetFailStatus(), nil
+	case "true", "1":
+		if status := config.GetFailStatus(); status != 0 {
+			return status, nil
+		}
+		return defaultFailStatus, nil
+	case "false", "0":
+		return 0, nil
+	}
+	status, err := strconv.Atoi(value)
+	if err != nil || status < 400 || status > 599 {
+		return 0, errors.New("Invalid fail, use true, false or a status code from 400 to 599")
+	}
+	return status, nil
+}
+
+// responseStatus maps a command result to an HTTP status. With fail mode off
+// every result is a 200; otherwise commands that could not be started answer
+// 500, timed out ones 504 and other failures the fail status.
+func responseStatus(response commands.CommandResponse, fail int) int {
+	switch {
+	case fail == 0 || response.Success:
+		return http.StatusOK
+	case response.StartFailed:
+		return http.StatusInternalServerError
+	case response.TimedOut:
+		return http.StatusGatewayTimeout
+	default:
+		return fail
+	}
+}
+
+// setResultHeaders reports a command result in the X-Exit-Code, X-Duration
+// and X-Timed-Out headers (or trailers, once the body has been sent)
+func setResultHeaders(w http.ResponseWriter, response commands.CommandResponse) {
+	w.Header().Set("X-Exit-Code", strconv.Itoa(response.ExitCode))
+	w.Header().Set("X-Duration", response.Duration)
+	w.Header().Set("X-Timed-Out", strconv.FormatBool(response.TimedOut))
+}
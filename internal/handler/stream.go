@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adaptive-scale/webshell/internal/commands"
+)
+
+// eventKeepAlive is how often an SSE comment is sent while a command is
+// silent, so proxies do not close the idle connection
+const eventKeepAlive = 15 * time.Second
+
+// Output streaming modes of /execute
+const (
+	streamNone = iota
+	// streamEvents sends Server-Sent Events tagged by stream
+	streamEvents
+	// streamRaw sends the output as plain chunked text with the result in trailers
+	streamRaw
+)
+
+// streamMode returns how the output of an /execute request is streamed:
+// Accept: text/event-stream or ?stream=sse selects events, ?stream=raw (or
+// true, 1) plain chunked text
+func streamMode(r *http.Request) int {
+	switch strings.ToLower(r.URL.Query().Get("stream")) {
+	case "sse", "events":
+		return streamEvents
+	case "raw", "true", "1":
+		return streamRaw
+	}
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		return streamEvents
+	}
+	return streamNone
+}
+
+// streamEventsResponse runs the command and sends its output as Server-Sent
+// Events. Each chunk is a "stdout" or "stderr" event whose data is the chunk as
+// a JSON string; a final "exit" event carries the CommandResponse without
+// output. The command is terminated if the client goes away.
+func streamEventsResponse(w http.ResponseWriter, r *http.Request, opts commands.Options) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var mu sync.Mutex
+	send := func(event string, data []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+		flusher.Flush()
+	}
+
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(eventKeepAlive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				mu.Lock()
+				fmt.Fprint(w, ": keepalive\n\n")
+				flusher.Flush()
+				mu.Unlock()
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	response := commands.StreamCommand(r.Context(), opts, func(stream string, data []byte) {
+		chunk, _ := json.Marshal(string(data))
+		send(stream, chunk)
+	})
+
+	result, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Failed to encode command response: %v", err)
+		return
+	}
+	send("exit", result)
+}
+
+// streamRawResponse runs the command and sends its output as it arrives in a
+// plain chunked response, only stdout or stderr if stream names one. As the
+// status is sent before the command finishes, the result is reported in the
+// X-Exit-Code, X-Duration, X-Timed-Out and X-Error trailers.
+func streamRawResponse(w http.ResponseWriter, r *http.Request, opts commands.Options, stream string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Trailer", "X-Exit-Code, X-Duration, X-Timed-Out, X-Error")
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	response := commands.StreamCommand(r.Context(), opts, func(source string, data []byte) {
+		if stream != "" && stream != "combined" && source != stream {
+			return
+		}
+		w.Write(data)
+		flusher.Flush()
+	})
+
+	setResultHeaders(w, response)
+	if response.Error != "" {
+		w.Header().Set("X-Error", response.Error)
+	}
+}
@@ -0,0 +1,466 @@
+// Package jobs runs commands in the background so clients can start long
+// scripts, disconnect and come back for the status and output later.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adaptive-scale/webshell/internal/cgroup"
+	"github.com/adaptive-scale/webshell/internal/commands"
+	"github.com/adaptive-scale/webshell/internal/config"
+	"github.com/adaptive-scale/webshell/internal/limits"
+)
+
+// States of a job
+const (
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+	StateCanceled  = "canceled"
+	// StateLost marks a job that was still running when the server stopped
+	StateLost = "lost"
+)
+
+var (
+	// ErrNotFound is returned for unknown job IDs
+	ErrNotFound = errors.New("job not found")
+	// ErrStoreFull is returned when the store holds the maximum number of
+	// jobs and all of them are still running
+	ErrStoreFull = errors.New("too many jobs, wait for running jobs to finish")
+)
+
+var validID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// Info describes a job
+type Info struct {
+	ID          string         `json:"id"`
+	Command     string         `json:"command"`
+	Owner       string         `json:"owner,omitempty"`
+	RunAs       string         `json:"run_as,omitempty"`
+	State       string         `json:"state"`
+	StartedAt   time.Time      `json:"started_at"`
+	FinishedAt  *time.Time     `json:"finished_at,omitempty"`
+	Duration    string         `json:"duration"`
+	ExitCode    *int           `json:"exit_code,omitempty"`
+	Error       string         `json:"error,omitempty"`
+	OutputBytes int64          `json:"output_bytes"`
+	Cgroup      *cgroup.Events `json:"cgroup,omitempty"`
+}
+
+// Job is a command running or finished in the background
+type Job struct {
+	mu       sync.Mutex
+	info     Info
+	output   output
+	cancel   context.CancelFunc
+	canceled bool
+	// changed is closed and replaced whenever output is written or the job
+	// finishes, waking clients waiting for more output
+	changed chan struct{}
+}
+
+// store holds all jobs by ID
+var store = struct {
+	sync.Mutex
+	jobs map[string]*Job
+}{jobs: make(map[string]*Job)}
+
+// Start runs the command in the background for the client identified by owner
+// and ip. The job takes a slot in limits.Executions until it finishes; a
+// *limits.LimitError is returned when the client may not run more commands.
+func Start(owner, ip string, opts commands.Options) (Info, error) {
+	release, err := limits.Executions.TryAcquire(owner, ip)
+	if err != nil {
+		return Info{}, err
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		release()
+		return Info{}, err
+	}
+
+	job := &Job{
+		info: Info{
+			ID:        id,
+			Command:   opts.Command + " " + strings.Join(opts.Args, " "),
+			Owner:     owner,
+			State:     StateRunning,
+			StartedAt: time.Now().UTC(),
+		},
+		changed: make(chan struct{}),
+	}
+	if opts.RunAs != nil {
+		job.info.RunAs = opts.RunAs.Username
+	}
+
+	if err := add(job); err != nil {
+		release()
+		return Info{}, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job.cancel = cancel
+	go func() {
+		defer release()
+		defer cancel()
+		response := commands.StreamCommand(ctx, opts, func(stream string, data []byte) {
+			job.write(data)
+		})
+		job.finish(response)
+	}()
+
+	log.Printf("Job %s started: %s", id, job.info.Command)
+	return job.snapshot(), nil
+}
+
+// add creates the job's output and puts it in the store, evicting the oldest
+// finished job when the store is full
+func add(job *Job) error {
+	store.Lock()
+	defer store.Unlock()
+
+	if max := config.GetMaxJobs(); max > 0 && len(store.jobs) >= max {
+		oldest := oldestFinished()
+		if oldest == nil {
+			return ErrStoreFull
+		}
+		removeLocked(oldest)
+	}
+
+	if dir := config.GetJobsDir(); dir != "" {
+		out, err := createFileOutput(filepath.Join(dir, job.info.ID+".log"), config.GetJobOutputLimit())
+		if err != nil {
+			return err
+		}
+		job.output = out
+		job.save()
+	} else {
+		job.output = newMemoryOutput(config.GetJobOutputLimit())
+	}
+	store.jobs[job.info.ID] = job
+	return nil
+}
+
+// oldestFinished returns the finished job that ended first, or nil
+func oldestFinished() *Job {
+	var oldest *Job
+	var oldestAt time.Time
+	for _, job := range store.jobs {
+		job.mu.Lock()
+		finishedAt := job.info.FinishedAt
+		job.mu.Unlock()
+		if finishedAt != nil && (oldest == nil || finishedAt.Before(oldestAt)) {
+			oldest, oldestAt = job, *finishedAt
+		}
+	}
+	return oldest
+}
+
+// removeLocked deletes the job and its output; the store must be locked
+func removeLocked(job *Job) {
+	delete(store.jobs, job.info.ID)
+	job.output.remove()
+	if dir := config.GetJobsDir(); dir != "" {
+		os.Remove(filepath.Join(dir, job.info.ID+".json"))
+	}
+}
+
+// write appends output and wakes waiting readers
+func (job *Job) write(data []byte) {
+	if _, err := job.output.Write(data); err != nil {
+		log.Printf("Failed to write output of job %s: %v", job.info.ID, err)
+	}
+	job.mu.Lock()
+	job.notifyLocked()
+	job.mu.Unlock()
+}
+
+// finish records the result, persists it and schedules the job's removal
+// after the retention period
+func (job *Job) finish(response commands.CommandResponse) {
+	job.mu.Lock()
+	now := time.Now().UTC()
+	exitCode := response.ExitCode
+	job.info.FinishedAt = &now
+	job.info.ExitCode = &exitCode
+	job.info.Error = response.Error
+	job.info.Cgroup = response.Cgroup
+	switch {
+	case job.canceled:
+		job.info.State = StateCanceled
+	case response.Success:
+		job.info.State = StateSucceeded
+	default:
+		job.info.State = StateFailed
+	}
+	job.notifyLocked()
+	job.mu.Unlock()
+
+	job.save()
+	job.output.close()
+	job.expire(config.GetJobRetention())
+
+	info := job.snapshot()
+	log.Printf("Job %s %s after %s", info.ID, info.State, info.Duration)
+}
+
+// expire removes the job after d, unless it was replaced or removed already
+func (job *Job) expire(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	time.AfterFunc(d, func() {
+		store.Lock()
+		defer store.Unlock()
+		if store.jobs[job.info.ID] == job {
+			removeLocked(job)
+		}
+	})
+}
+
+// notifyLocked wakes everyone waiting on changed; job.mu must be held
+func (job *Job) notifyLocked() {
+	close(job.changed)
+	job.changed = make(chan struct{})
+}
+
+// snapshot returns the current Info of the job
+func (job *Job) snapshot() Info {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	info := job.info
+	end := time.Now()
+	if info.FinishedAt != nil {
+		end = *info.FinishedAt
+	}
+	info.Duration = end.Sub(info.StartedAt).Round(time.Millisecond).String()
+	info.OutputBytes = job.output.size()
+	return info
+}
+
+// save writes the job's Info next to its output when jobs are kept on disk
+func (job *Job) save() {
+	dir := config.GetJobsDir()
+	if dir == "" {
+		return
+	}
+	data, err := json.MarshalIndent(job.snapshot(), "", "  ")
+	if err != nil {
+		return
+	}
+	path := filepath.Join(dir, job.info.ID+".json")
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		log.Printf("Failed to save job %s: %v", job.info.ID, err)
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.Printf("Failed to save job %s: %v", job.info.ID, err)
+	}
+}
+
+// Get returns the Info of a job
+func Get(id string) (Info, error) {
+	job, ok := find(id)
+	if !ok {
+		return Info{}, ErrNotFound
+	}
+	return job.snapshot(), nil
+}
+
+// List returns all jobs, newest first
+func List() []Info {
+	store.Lock()
+	jobs := make([]*Job, 0, len(store.jobs))
+	for _, job := range store.jobs {
+		jobs = append(jobs, job)
+	}
+	store.Unlock()
+
+	infos := make([]Info, 0, len(jobs))
+	for _, job := range jobs {
+		infos = append(infos, job.snapshot())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].StartedAt.After(infos[j].StartedAt)
+	})
+	return infos
+}
+
+// Cancel terminates a running job, or removes a finished one with its output.
+// It returns the job's Info and whether it was removed.
+func Cancel(id string) (Info, bool, error) {
+	job, ok := find(id)
+	if !ok {
+		return Info{}, false, ErrNotFound
+	}
+
+	job.mu.Lock()
+	running := job.info.FinishedAt == nil
+	if running {
+		job.canceled = true
+	}
+	job.mu.Unlock()
+
+	if running {
+		job.cancel()
+		log.Printf("Job %s canceled", id)
+		return job.snapshot(), false, nil
+	}
+
+	store.Lock()
+	if store.jobs[id] == job {
+		removeLocked(job)
+	}
+	store.Unlock()
+	return job.snapshot(), true, nil
+}
+
+// Output returns up to max bytes of a job's output from offset, along with
+// the offset of the first byte returned, which is later than offset if that
+// output was dropped. If there is no output past offset yet and the job is
+// still running, it waits up to wait for more, or until ctx is done.
+func Output(ctx context.Context, id string, offset int64, max int, wait time.Duration) ([]byte, int64, Info, error) {
+	job, ok := find(id)
+	if !ok {
+		return nil, 0, Info{}, ErrNotFound
+	}
+
+	var timeout <-chan time.Time
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for {
+		job.mu.Lock()
+		changed := job.changed
+		finished := job.info.FinishedAt != nil
+		job.mu.Unlock()
+
+		data, start, err := job.output.read(offset, max)
+		if err != nil || len(data) > 0 || finished || timeout == nil {
+			return data, start, job.snapshot(), err
+		}
+
+		select {
+		case <-changed:
+		case <-timeout:
+			timeout = nil
+		case <-ctx.Done():
+			timeout = nil
+		}
+	}
+}
+
+func find(id string) (*Job, bool) {
+	store.Lock()
+	defer store.Unlock()
+	job, ok := store.jobs[id]
+	return job, ok
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Load restores the jobs kept in the jobs directory by an earlier run. Jobs
+// that were still running when that run stopped are marked lost.
+func Load() error {
+	dir := config.GetJobsDir()
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		id := strings.TrimSuffix(filepath.Base(path), ".json")
+		if !validID.MatchString(id) {
+			continue
+		}
+		job, err := loadJob(dir, id)
+		if err != nil {
+			log.Printf("Failed to load job %s: %v", id, err)
+			continue
+		}
+
+		retention := config.GetJobRetention()
+		if retention > 0 {
+			retention -= time.Since(*job.info.FinishedAt)
+			if retention <= 0 {
+				job.output.remove()
+				os.Remove(path)
+				continue
+			}
+		}
+		store.Lock()
+		store.jobs[id] = job
+		store.Unlock()
+		job.expire(retention)
+	}
+
+	store.Lock()
+	defer store.Unlock()
+	if len(store.jobs) > 0 {
+		log.Printf("Loaded %d jobs from %s", len(store.jobs), dir)
+	}
+	return nil
+}
+
+// loadJob reads a job saved by an earlier run
+func loadJob(dir, id string) (*Job, error) {
+	data, err := os.ReadFile(filepath.Join(dir, id+".json"))
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{changed: make(chan struct{}), cancel: func() {}}
+	if err := json.Unmarshal(data, &job.info); err != nil {
+		return nil, err
+	}
+	if job.info.ID != id {
+		return nil, fmt.Errorf("job file contains job %q", job.info.ID)
+	}
+
+	out, err := openFileOutput(filepath.Join(dir, id+".log"), job.info.OutputBytes)
+	if err != nil {
+		return nil, err
+	}
+	job.output = out
+
+	if job.info.FinishedAt == nil {
+		// The server stopped while the job was running
+		modified := job.info.StartedAt
+		if stat, err := os.Stat(filepath.Join(dir, id+".log")); err == nil {
+			modified = stat.ModTime().UTC()
+		}
+		job.info.FinishedAt = &modified
+		job.info.State = StateLost
+		job.info.Error = "server stopped while the job was running"
+		job.save()
+	}
+	return job, nil
+}
@@ -0,0 +1,224 @@
+package jobs
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// output stores what a job wrote. Offsets count every byte the job ever
+// wrote, so they stay valid when old output is dropped.
+type output interface {
+	io.Writer
+	// read returns up to max bytes from offset, or from the oldest byte still
+	// kept if offset was dropped, along with the offset of the first byte
+	// returned
+	read(offset int64, max int) ([]byte, int64, error)
+	// size returns the number of bytes written
+	size() int64
+	// close releases the storage; remove also deletes it
+	close() error
+	remove() error
+}
+
+// memoryOutput keeps at least the last limit bytes of output in memory. Old
+// output is only dropped once twice the limit is buffered, so not every write
+// has to move the kept bytes.
+type memoryOutput struct {
+	mu    sync.Mutex
+	limit int
+	data  []byte
+	base  int64 // offset of data[0]
+}
+
+func newMemoryOutput(limit int) *memoryOutput {
+	return &memoryOutput{limit: limit}
+}
+
+func (m *memoryOutput) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data = append(m.data, p...)
+	if m.limit > 0 && len(m.data) > 2*m.limit {
+		drop := len(m.data) - m.limit
+		m.data = append(m.data[:0:0], m.data[drop:]...)
+		m.base += int64(drop)
+	}
+	return len(p), nil
+}
+
+func (m *memoryOutput) read(offset int64, max int) ([]byte, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	end := m.base + int64(len(m.data))
+	if offset < m.base {
+		offset = m.base
+	}
+	if offset > end {
+		offset = end
+	}
+	data := m.data[offset-m.base:]
+	if len(data) > max {
+		data = data[:max]
+	}
+	return append([]byte(nil), data...), offset, nil
+}
+
+func (m *memoryOutput) size() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.base + int64(len(m.data))
+}
+
+func (m *memoryOutput) close() error {
+	return nil
+}
+
+func (m *memoryOutput) remove() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data = nil
+	return nil
+}
+
+// fileOutput keeps at least the last limit bytes of output in a file, like
+// memoryOutput: once twice the limit is stored, the file is replaced by one
+// holding only the last limit bytes. The file is opened for each read, so
+// finished jobs hold no file descriptors.
+type fileOutput struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File // nil once closed
+	limit   int64
+	base    int64 // offset of the first byte in the file
+	written int64
+}
+
+// createFileOutput creates a new output file at path keeping limit bytes, 0
+// for no limit
+func createFileOutput(path string, limit int) (*fileOutput, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileOutput{path: path, file: f, limit: int64(limit)}, nil
+}
+
+// openFileOutput opens the output file of a job from an earlier run for
+// reading. total is the number of bytes the job wrote, of which the file
+// holds the last ones.
+func openFileOutput(path string, total int64) (*fileOutput, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	o := &fileOutput{path: path, written: info.Size()}
+	if total > info.Size() {
+		o.base, o.written = total-info.Size(), total
+	}
+	return o, nil
+}
+
+func (o *fileOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.file == nil {
+		return 0, os.ErrClosed
+	}
+	n, err := o.file.Write(p)
+	o.written += int64(n)
+	if err == nil && o.limit > 0 && o.written-o.base > 2*o.limit {
+		err = o.trimLocked()
+	}
+	return n, err
+}
+
+// trimLocked replaces the file with one holding the last limit bytes; o.mu
+// must be held. Readers that already opened the old file keep reading it.
+func (o *fileOutput) trimLocked() error {
+	src, err := os.Open(o.path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	keep := o.written - o.limit
+	tmp, err := os.OpenFile(o.path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, io.NewSectionReader(src, keep-o.base, o.limit))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(o.path+".tmp", o.path)
+	}
+	if err != nil {
+		os.Remove(o.path + ".tmp")
+		return err
+	}
+
+	o.base = keep
+	f, err := os.OpenFile(o.path, os.O_WRONLY|os.O_APPEND, 0)
+	o.file.Close()
+	o.file = f
+	if err != nil {
+		o.file = nil
+	}
+	return err
+}
+
+func (o *fileOutput) read(offset int64, max int) ([]byte, int64, error) {
+	// The file is opened under the lock, so it matches base even if it is
+	// trimmed while being read
+	o.mu.Lock()
+	base, end := o.base, o.written
+	if offset < base {
+		offset = base
+	}
+	if offset > end {
+		offset = end
+	}
+	if remaining := end - offset; int64(max) > remaining {
+		max = int(remaining)
+	}
+	if max == 0 {
+		o.mu.Unlock()
+		return nil, offset, nil
+	}
+	f, err := os.Open(o.path)
+	o.mu.Unlock()
+	if err != nil {
+		return nil, offset, err
+	}
+	defer f.Close()
+
+	data := make([]byte, max)
+	n, err := f.ReadAt(data, offset-base)
+	if err == io.EOF {
+		err = nil
+	}
+	return data[:n], offset, err
+}
+
+func (o *fileOutput) size() int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.written
+}
+
+func (o *fileOutput) close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.file == nil {
+		return nil
+	}
+	err := o.file.Close()
+	o.file = nil
+	return err
+}
+
+func (o *fileOutput) remove() error {
+	o.close()
+	return os.Remove(o.path)
+}
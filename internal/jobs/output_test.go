@@ -0,0 +1,67 @@
+package jobs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemoryOutputKeepsOffsetsWhenDropping(t *testing.T) {
+	out := newMemoryOutput(4)
+	for _, chunk := range []string{"abc", "def", "ghi"} {
+		out.Write([]byte(chunk))
+	}
+
+	if size := out.size(); size != 9 {
+		t.Fatalf("size = %d, want 9", size)
+	}
+	// 9 bytes exceed twice the limit, so everything but the last 4 is dropped
+	data, start, _ := out.read(0, 100)
+	if string(data) != "fghi" || start != 5 {
+		t.Errorf("read(0) = %q at %d, want \"fghi\" at 5", data, start)
+	}
+	data, start, _ = out.read(7, 1)
+	if string(data) != "h" || start != 7 {
+		t.Errorf("read(7, 1) = %q at %d, want \"h\" at 7", data, start)
+	}
+	data, start, _ = out.read(20, 100)
+	if len(data) != 0 || start != 9 {
+		t.Errorf("read(20) = %q at %d, want nothing at 9", data, start)
+	}
+}
+
+func TestFileOutputKeepsOffsetsWhenDropping(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.log")
+	out, err := createFileOutput(path, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chunk := range []string{"abc", "def", "ghi"} {
+		if _, err := out.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out.close()
+
+	if data, _ := os.ReadFile(path); string(data) != "fghi" {
+		t.Errorf("file holds %q, want \"fghi\"", data)
+	}
+	data, start, _ := out.read(0, 100)
+	if string(data) != "fghi" || start != 5 {
+		t.Errorf("read(0) = %q at %d, want \"fghi\" at 5", data, start)
+	}
+	data, start, _ = out.read(7, 1)
+	if string(data) != "h" || start != 7 {
+		t.Errorf("read(7, 1) = %q at %d, want \"h\" at 7", data, start)
+	}
+
+	// A later run restores the offsets from the saved byte count
+	loaded, err := openFileOutput(path, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, start, _ = loaded.read(6, 100)
+	if string(data) != "ghi" || start != 6 || loaded.size() != 9 {
+		t.Errorf("loaded read(6) = %q at %d, size %d", data, start, loaded.size())
+	}
+}
@@ -0,0 +1,170 @@
+package limits
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/adaptive-scale/webshell/internal/config"
+)
+
+// Limiters for the resources that spawn processes
+var (
+	Sessions   = New("terminal sessions", config.GetSessionLimits)
+	Viewers    = New("shared session viewers", config.GetViewerLimits)
+	Executions = New("command executions", config.GetExecutionLimits)
+)
+
+// LimitError is returned when a limit is reached
+type LimitError struct {
+	Resource string
+	Scope    string // "server", "token" or "ip"
+	Limit    int
+}
+
+func (e *LimitError) Error() string {
+	if e.Scope == "server" {
+		return fmt.Sprintf("too many concurrent %s (limit %d)", e.Resource, e.Limit)
+	}
+	return fmt.Sprintf("too many concurrent %s for this %s (limit %d)", e.Resource, e.Scope, e.Limit)
+}
+
+// Limiter counts concurrent uses of a resource in total, per token and per
+// client IP
+type Limiter struct {
+	resource string
+	limits   func() config.Limits
+
+	mu      sync.Mutex
+	active  int
+	queued  int
+	byToken map[string]int
+	byIP    map[string]int
+	// released is closed and replaced whenever a slot is released, waking
+	// queued callers
+	released chan struct{}
+}
+
+// New creates a limiter for resource whose caps are read from limits on every
+// acquisition
+func New(resource string, limits func() config.Limits) *Limiter {
+	return &Limiter{
+		resource: resource,
+		limits:   limits,
+		byToken:  make(map[string]int),
+		byIP:     make(map[string]int),
+		released: make(chan struct{}),
+	}
+}
+
+// TryAcquire takes a slot for token and ip, or returns a *LimitError if one
+// of the limits is reached. The returned function releases the slot.
+func (l *Limiter) TryAcquire(token, ip string) (func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.check(token, ip); err != nil {
+		return nil, err
+	}
+	return l.take(token, ip), nil
+}
+
+// Acquire takes a slot for token and ip, waiting for one to be released until
+// ctx is done. It returns the last *LimitError if no slot became free.
+func (l *Limiter) Acquire(ctx context.Context, token, ip string) (func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	queued := false
+	defer func() {
+		if queued {
+			l.queued--
+		}
+	}()
+
+	for {
+		err := l.check(token, ip)
+		if err == nil {
+			return l.take(token, ip), nil
+		}
+		if !queued {
+			queued = true
+			l.queued++
+		}
+
+		released := l.released
+		l.mu.Unlock()
+		select {
+		case <-released:
+			l.mu.Lock()
+		case <-ctx.Done():
+			l.mu.Lock()
+			return nil, err
+		}
+	}
+}
+
+// check returns an error if another slot for token and ip would exceed a
+// limit. Must be called with the lock held.
+func (l *Limiter) check(token, ip string) error {
+	limits := l.limits()
+	switch {
+	case limits.Global > 0 && l.active >= limits.Global:
+		return &LimitError{Resource: l.resource, Scope: "server", Limit: limits.Global}
+	case limits.PerToken > 0 && l.byToken[token] >= limits.PerToken:
+		return &LimitError{Resource: l.resource, Scope: "token", Limit: limits.PerToken}
+	case limits.PerIP > 0 && l.byIP[ip] >= limits.PerIP:
+		return &LimitError{Resource: l.resource, Scope: "ip", Limit: limits.PerIP}
+	}
+	return nil
+}
+
+// take counts a slot and returns its release function. Must be called with
+// the lock held.
+func (l *Limiter) take(token, ip string) func() {
+	l.active++
+	l.byToken[token]++
+	l.byIP[ip]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.active--
+			decrement(l.byToken, token)
+			decrement(l.byIP, ip)
+			close(l.released)
+			l.released = make(chan struct{})
+		})
+	}
+}
+
+func decrement(counts map[string]int, key string) {
+	if counts[key] <= 1 {
+		delete(counts, key)
+		return
+	}
+	counts[key]--
+}
+
+// Usage describes how much of a resource is in use
+type Usage struct {
+	config.Limits
+	Active  int `json:"active"`
+	Queued  int `json:"queued"`
+	Tokens  int `json:"tokens"`
+	Clients int `json:"clients"`
+}
+
+// Usage returns the current usage and limits
+func (l *Limiter) Usage() Usage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return Usage{
+		Limits:  l.limits(),
+		Active:  l.active,
+		Queued:  l.queued,
+		Tokens:  len(l.byToken),
+		Clients: len(l.byIP),
+	}
+}
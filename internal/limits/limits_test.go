@@ -0,0 +1,119 @@
+package limits
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/adaptive-scale/webshell/internal/config"
+)
+
+func newLimiter(limits config.Limits) *Limiter {
+	return New("things", func() config.Limits { return limits })
+}
+
+func TestTryAcquireCaps(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits config.Limits
+		// second is the client taking a slot after "a" at 1.1.1.1 has one
+		token, ip string
+		scope     string
+	}{
+		{"server", config.Limits{Global: 1}, "b", "2.2.2.2", "server"},
+		{"token", config.Limits{PerToken: 1}, "a", "2.2.2.2", "token"},
+		{"ip", config.Limits{PerIP: 1}, "b", "1.1.1.1", "ip"},
+		{"other token", config.Limits{PerToken: 1}, "b", "1.1.1.1", ""},
+		{"other ip", config.Limits{PerIP: 1}, "a", "2.2.2.2", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLimiter(tt.limits)
+			if _, err := l.TryAcquire("a", "1.1.1.1"); err != nil {
+				t.Fatalf("first slot: %v", err)
+			}
+			_, err := l.TryAcquire(tt.token, tt.ip)
+			var limitErr *LimitError
+			switch {
+			case tt.scope == "" && err != nil:
+				t.Fatalf("second slot: %v", err)
+			case tt.scope != "" && !errors.As(err, &limitErr):
+				t.Fatalf("second slot: got %v, want a limit error", err)
+			case tt.scope != "" && (limitErr.Scope != tt.scope || limitErr.Limit != 1):
+				t.Fatalf("got %+v, want scope %s", limitErr, tt.scope)
+			}
+		})
+	}
+}
+
+func TestReleaseIsIdempotent(t *testing.T) {
+	l := newLimiter(config.Limits{Global: 2})
+	release, _ := l.TryAcquire("a", "1.1.1.1")
+	if _, err := l.TryAcquire("a", "1.1.1.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	release()
+	release()
+	usage := l.Usage()
+	if usage.Active != 1 || usage.Tokens != 1 || usage.Clients != 1 {
+		t.Fatalf("after release: %+v", usage)
+	}
+
+	// Releasing the last slot of a token and IP forgets them
+	l = newLimiter(config.Limits{})
+	release, _ = l.TryAcquire("a", "1.1.1.1")
+	release()
+	if usage := l.Usage(); usage.Active != 0 || usage.Tokens != 0 || usage.Clients != 0 {
+		t.Fatalf("after releasing everything: %+v", usage)
+	}
+}
+
+func TestAcquireWaitsForRelease(t *testing.T) {
+	l := newLimiter(config.Limits{Global: 1})
+	release, _ := l.TryAcquire("a", "1.1.1.1")
+
+	acquired := make(chan error)
+	go func() {
+		_, err := l.Acquire(context.Background(), "b", "2.2.2.2")
+		acquired <- err
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for l.Usage().Queued != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Acquire did not queue")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-acquired:
+		t.Fatalf("Acquire returned %v while the limit was reached", err)
+	default:
+	}
+
+	release()
+	if err := <-acquired; err != nil {
+		t.Fatalf("Acquire after release: %v", err)
+	}
+	if usage := l.Usage(); usage.Active != 1 || usage.Queued != 0 {
+		t.Fatalf("after wakeup: %+v", usage)
+	}
+}
+
+func TestAcquireGivesUpWhenContextIsDone(t *testing.T) {
+	l := newLimiter(config.Limits{PerToken: 1})
+	l.TryAcquire("a", "1.1.1.1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := l.Acquire(ctx, "a", "2.2.2.2")
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Scope != "token" {
+		t.Fatalf("got %v, want the token limit error", err)
+	}
+	if usage := l.Usage(); usage.Active != 1 || usage.Queued != 0 {
+		t.Fatalf("after giving up: %+v", usage)
+	}
+}
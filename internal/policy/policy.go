@@ -0,0 +1,471 @@
+// Package policy decides which commands clients may run and which files they
+// may upload or download. A policy is an ordered list of allow and deny rules
+// loaded from a JSON file; the first rule matching a request decides, and the
+// policy's default applies when none does. Without a policy everything is
+// allowed.
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Actions a rule can take
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// Sources a request can come from
+const (
+	SourceExecute  = "execute"
+	SourceJobs     = "jobs"
+	SourceTerminal = "terminal"
+	SourceUpload   = "upload"
+	SourceDownload = "download"
+)
+
+// Rule allows or denies the requests it matches. Every condition that is set
+// must match; a list matches if any of its patterns does. Rules with command
+// conditions (executables, args, cwd, env) never match file transfers and
+// rules with paths never match commands; rules with neither match both.
+type Rule struct {
+	Name   string `json:"name,omitempty"`
+	Action string `json:"action"`
+	// Reason is returned to clients whose command the rule denies
+	Reason string `json:"reason,omitempty"`
+	// Tokens and Roles restrict the rule to named tokens or tokens with one
+	// of the roles; the rule applies to everyone if both are empty
+	Tokens []string `json:"tokens,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+	// Sources restricts the rule to execute, jobs, terminal, upload or
+	// download
+	Sources []string `json:"sources,omitempty"`
+	// Executables are path patterns; patterns without a slash match the
+	// executable's base name
+	Executables []string `json:"executables,omitempty"`
+	// Args are regular expressions matched against the arguments joined by
+	// single spaces
+	Args []string `json:"args,omitempty"`
+	// Cwd are path patterns for the working directory
+	Cwd []string `json:"cwd,omitempty"`
+	// Env maps variable names to value patterns; every variable must be set
+	// by the request and match
+	Env map[string]string `json:"env,omitempty"`
+	// Paths are path patterns for the file uploaded or downloaded
+	Paths []string `json:"paths,omitempty"`
+
+	args []*regexp.Regexp
+	env  map[string]*regexp.Regexp
+}
+
+// Policy is an ordered list of rules and the action taken when none matches
+type Policy struct {
+	Default string `json:"default,omitempty"`
+	Rules   []Rule `json:"rules"`
+}
+
+// Request is a command or file transfer to evaluate
+type Request struct {
+	Source string
+	// Token is the name of the named token the request was made with, empty
+	// for the main token or without authentication
+	Token   string
+	Roles   []string
+	Command string
+	Args    []string
+	// Dir is the working directory, the server's if empty
+	Dir string
+	// Env holds the KEY=VALUE variables set by the request
+	Env []string
+	// Path is the file of an upload or download, which have no command
+	Path string
+}
+
+// Decision is the outcome of evaluating a request
+type Decision struct {
+	Allowed bool `json:"allowed"`
+	// Rule names the deciding rule, empty if the default applied
+	Rule       string `json:"rule,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	Source     string `json:"source"`
+	Executable string `json:"executable,omitempty"`
+	Cwd        string `json:"cwd,omitempty"`
+	Path       string `json:"path,omitempty"`
+}
+
+var current *Policy
+
+// Load reads the policy from a JSON file
+func Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var p Policy
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&p); err != nil {
+		return fmt.Errorf("invalid policy file %s: %w", path, err)
+	}
+	if err := p.compile(); err != nil {
+		return fmt.Errorf("invalid policy file %s: %w", path, err)
+	}
+	current = &p
+	return nil
+}
+
+// Enabled tells whether a policy is loaded
+func Enabled() bool {
+	return current != nil
+}
+
+// RuleCount returns the number of rules in the policy
+func RuleCount() int {
+	if current == nil {
+		return 0
+	}
+	return len(current.Rules)
+}
+
+// compile checks the policy and prepares its patterns
+func (p *Policy) compile() error {
+	switch p.Default {
+	case "":
+		p.Default = Deny
+	case Allow, Deny:
+	default:
+		return fmt.Errorf("default must be %q or %q", Allow, Deny)
+	}
+
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		name := rule.name(i)
+		if rule.Action != Allow && rule.Action != Deny {
+			return fmt.Errorf("%s: action must be %q or %q", name, Allow, Deny)
+		}
+		for _, source := range rule.Sources {
+			if source != SourceExecute && source != SourceJobs && source != SourceTerminal &&
+				source != SourceUpload && source != SourceDownload {
+				return fmt.Errorf("%s: unknown source %q", name, source)
+			}
+		}
+		if len(rule.Paths) > 0 && rule.hasCommandConditions() {
+			return fmt.Errorf("%s: paths cannot be combined with executables, args, cwd or env", name)
+		}
+		patterns := append(append([]string(nil), rule.Executables...), rule.Cwd...)
+		for _, pattern := range append(patterns, rule.Paths...) {
+			if _, err := filepath.Match(strings.TrimSuffix(pattern, "/**"), ""); err != nil {
+				return fmt.Errorf("%s: invalid pattern %q", name, pattern)
+			}
+		}
+		for _, expr := range rule.Args {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return fmt.Errorf("%s: invalid args pattern: %w", name, err)
+			}
+			rule.args = append(rule.args, re)
+		}
+		rule.env = make(map[string]*regexp.Regexp, len(rule.Env))
+		for key, pattern := range rule.Env {
+			rule.env[key] = globRegexp(pattern)
+		}
+	}
+	return nil
+}
+
+// name returns the rule's name, or its position if it has none
+func (rule *Rule) name(i int) string {
+	if rule.Name != "" {
+		return rule.Name
+	}
+	return fmt.Sprintf("rule %d", i+1)
+}
+
+// Evaluate decides whether req may run, or for uploads and downloads whether
+// the file may be transferred
+func Evaluate(req Request) Decision {
+	if IsFileSource(req.Source) {
+		return evaluateFile(req)
+	}
+
+	executables := resolve(req.Command, req.Dir)
+	cwd := req.Dir
+	if cwd == "" {
+		cwd, _ = os.Getwd()
+	}
+	if abs, err := filepath.Abs(cwd); err == nil {
+		cwd = abs
+	}
+	decision := Decision{Source: req.Source, Executable: executables[0], Cwd: cwd}
+
+	env := make(map[string]string, len(req.Env))
+	for _, kv := range req.Env {
+		if key, value, ok := strings.Cut(kv, "="); ok {
+			env[key] = value
+		}
+	}
+	args := strings.Join(req.Args, " ")
+
+	return decide(decision, "command", func(rule *Rule) bool {
+		return rule.matches(req, executables, args, cwd, env)
+	})
+}
+
+// evaluateFile decides whether the file of an upload or download may be
+// transferred
+func evaluateFile(req Request) Decision {
+	paths := resolveFile(req.Path)
+	decision := Decision{Source: req.Source, Path: paths[0]}
+	return decide(decision, "file", func(rule *Rule) bool {
+		return rule.matchesFile(req, paths)
+	})
+}
+
+// decide completes decision with the first rule that match accepts, or the
+// policy's default. what names the kind of request in the default reason.
+func decide(decision Decision, what string, match func(*Rule) bool) Decision {
+	if current == nil {
+		decision.Allowed = true
+		return decision
+	}
+
+	for i := range current.Rules {
+		rule := &current.Rules[i]
+		if !match(rule) {
+			continue
+		}
+		decision.Allowed = rule.Action == Allow
+		decision.Rule = rule.name(i)
+		decision.Reason = rule.Reason
+		if !decision.Allowed && decision.Reason == "" {
+			decision.Reason = "denied by " + decision.Rule
+		}
+		return decision
+	}
+
+	decision.Allowed = current.Default == Allow
+	if !decision.Allowed {
+		decision.Reason = "no rule allows this " + what
+	}
+	return decision
+}
+
+// IsFileSource tells whether source is an upload or download
+func IsFileSource(source string) bool {
+	return source == SourceUpload || source == SourceDownload
+}
+
+// matches tells whether every condition of the rule holds for the request
+func (rule *Rule) matches(req Request, executables []string, args, cwd string, env map[string]string) bool {
+	if len(rule.Paths) > 0 || !rule.matchesSubject(req) {
+		return false
+	}
+	if len(rule.Executables) > 0 && !matchExecutable(rule.Executables, executables) {
+		return false
+	}
+	if len(rule.args) > 0 && !matchAnyRegexp(rule.args, args) {
+		return false
+	}
+	if len(rule.Cwd) > 0 && !matchAnyPath(rule.Cwd, cwd) {
+		return false
+	}
+	for key, re := range rule.env {
+		value, ok := env[key]
+		if !ok || !re.MatchString(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesFile tells whether the rule applies to the upload or download of a
+// file with the given paths
+func (rule *Rule) matchesFile(req Request, paths []string) bool {
+	if rule.hasCommandConditions() || !rule.matchesSubject(req) {
+		return false
+	}
+	if len(rule.Paths) > 0 {
+		for _, path := range paths {
+			if matchAnyPath(rule.Paths, path) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// matchesSubject tells whether the rule's tokens, roles and sources match
+func (rule *Rule) matchesSubject(req Request) bool {
+	if len(rule.Tokens) > 0 || len(rule.Roles) > 0 {
+		if !contains(rule.Tokens, req.Token) && !containsAny(rule.Roles, req.Roles) {
+			return false
+		}
+	}
+	return len(rule.Sources) == 0 || contains(rule.Sources, req.Source)
+}
+
+// hasCommandConditions tells whether the rule sets conditions that only
+// commands have
+func (rule *Rule) hasCommandConditions() bool {
+	return len(rule.Executables) > 0 || len(rule.Args) > 0 || len(rule.Cwd) > 0 || len(rule.Env) > 0
+}
+
+// AllowedCommands returns the executable patterns everyone may run through
+// /execute: those of allow rules that are not restricted to tokens or roles
+// and have no other conditions, kept only if the policy, evaluated for a
+// client without a token or roles, allows them, so patterns shadowed by an
+// earlier deny rule are left out. It is empty without a policy.
+func AllowedCommands() []string {
+	if current == nil {
+		return nil
+	}
+	var commands []string
+	for _, rule := range current.Rules {
+		if rule.Action != Allow || len(rule.Tokens) > 0 || len(rule.Roles) > 0 || len(rule.Paths) > 0 ||
+			len(rule.Args) > 0 || len(rule.Cwd) > 0 || len(rule.Env) > 0 ||
+			(len(rule.Sources) > 0 && !contains(rule.Sources, SourceExecute)) {
+			continue
+		}
+		for _, pattern := range rule.Executables {
+			if Evaluate(Request{Source: SourceExecute, Command: pattern}).Allowed {
+				commands = append(commands, pattern)
+			}
+		}
+	}
+	return commands
+}
+
+// resolve returns the path command runs as, followed by the target if that
+// path is a symlink. Names without a slash are looked up in PATH like
+// exec.Command does, relative paths are taken from dir.
+func resolve(command, dir string) []string {
+	path := command
+	if !strings.Contains(command, "/") {
+		found, err := exec.LookPath(command)
+		if err != nil {
+			return []string{command}
+		}
+		path = found
+	} else if !filepath.IsAbs(command) {
+		path = filepath.Join(dir, command)
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	paths := []string{path}
+	if target, err := filepath.EvalSymlinks(path); err == nil && target != path {
+		paths = append(paths, target)
+	}
+	return paths
+}
+
+// resolveFile returns the absolute path of a file, followed by its target if
+// a symlink is involved. Files that do not exist yet are resolved through
+// their directory.
+func resolveFile(path string) []string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	paths := []string{path}
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		if dir, err := filepath.EvalSymlinks(filepath.Dir(path)); err == nil {
+			target = filepath.Join(dir, filepath.Base(path))
+		}
+	}
+	if target != "" && target != path {
+		paths = append(paths, target)
+	}
+	return paths
+}
+
+// matchExecutable tells whether any of the executable's paths matches a pattern
+func matchExecutable(patterns, paths []string) bool {
+	for _, pattern := range patterns {
+		for _, path := range paths {
+			if !strings.Contains(pattern, "/") {
+				path = filepath.Base(path)
+			}
+			if matchPath(pattern, path) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchAnyPath(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if matchPath(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath matches a path against a pattern in filepath.Match syntax. A
+// pattern ending in /** also matches everything below the directory.
+func matchPath(pattern, path string) bool {
+	if dir := strings.TrimSuffix(pattern, "/**"); dir != pattern {
+		if dir == "" {
+			return true
+		}
+		if ok, _ := filepath.Match(dir, path); ok {
+			return true
+		}
+		for parent := filepath.Dir(path); parent != path; path, parent = parent, filepath.Dir(parent) {
+			if ok, _ := filepath.Match(dir, parent); ok {
+				return true
+			}
+		}
+		return false
+	}
+	ok, _ := filepath.Match(pattern, path)
+	return ok
+}
+
+func matchAnyRegexp(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// globRegexp turns a pattern in which * matches any run of characters and ?
+// any single character into an anchored regular expression
+func globRegexp(pattern string) *regexp.Regexp {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	return regexp.MustCompile("^(?s:" + expr + ")$")
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(list, values []string) bool {
+	for _, value := range values {
+		if contains(list, value) {
+			return true
+		}
+	}
+	return false
+}
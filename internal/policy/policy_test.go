@@ -0,0 +1,122 @@
+//go:build !windows
+
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	err := os.WriteFile(path, []byte(`{
+		"default": "deny",
+		"rules": [
+			{"name": "no-preload", "action": "deny", "reason": "LD_PRELOAD is not allowed", "env": {"LD_PRELOAD": "*"}},
+			{"action": "deny", "executables": ["rm"], "args": ["(^| )-[a-zA-Z]*r"]},
+			{"action": "allow", "executables": ["ls", "rm", "/bin/echo"]},
+			{"name": "deploy", "action": "allow", "roles": ["ops"], "sources": ["jobs"], "cwd": ["/srv/**"]}
+		]
+	}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Load(path); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { current = nil }()
+
+	for _, test := range []struct {
+		req     Request
+		allowed bool
+		rule    string
+	}{
+		{Request{Command: "ls", Args: []string{"-la"}}, true, "rule 3"},
+		{Request{Command: "ls", Env: []string{"LD_PRELOAD=/tmp/x.so"}}, false, "no-preload"},
+		{Request{Command: "rm", Args: []string{"-fr", "/tmp/x"}}, false, "rule 2"},
+		{Request{Command: "rm", Args: []string{"/tmp/x"}}, true, "rule 3"},
+		{Request{Command: "/bin/echo"}, true, "rule 3"},
+		{Request{Command: "cat"}, false, ""},
+		{Request{Command: "make", Source: SourceJobs, Roles: []string{"ops"}, Dir: "/srv/app"}, true, "deploy"},
+		{Request{Command: "make", Source: SourceJobs, Roles: []string{"ops"}, Dir: "/srv"}, true, "deploy"},
+		{Request{Command: "make", Source: SourceJobs, Roles: []string{"ops"}, Dir: "/srvx"}, false, ""},
+		{Request{Command: "make", Source: SourceExecute, Roles: []string{"ops"}, Dir: "/srv/app"}, false, ""},
+		{Request{Command: "make", Source: SourceJobs, Token: "ci", Dir: "/srv/app"}, false, ""},
+	} {
+		decision := Evaluate(test.req)
+		if decision.Allowed != test.allowed || decision.Rule != test.rule {
+			t.Errorf("%+v: %+v", test.req, decision)
+		}
+		if !decision.Allowed && decision.Reason == "" {
+			t.Errorf("%+v: denied without a reason", test.req)
+		}
+	}
+}
+
+func TestEvaluateFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Symlink("/etc", filepath.Join(dir, "etc")); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "policy.json")
+	err := os.WriteFile(path, []byte(`{
+		"default": "deny",
+		"rules": [
+			{"action": "allow", "executables": ["ls"]},
+			{"name": "secrets", "action": "deny", "paths": ["/etc/**"]},
+			{"name": "ops", "action": "allow", "roles": ["ops"]},
+			{"name": "incoming", "action": "allow", "sources": ["upload"], "paths": ["/srv/incoming/**"]}
+		]
+	}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Load(path); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { current = nil }()
+
+	for _, test := range []struct {
+		req     Request
+		allowed bool
+		rule    string
+	}{
+		{Request{Source: SourceUpload, Path: "/srv/incoming/a.txt"}, true, "incoming"},
+		{Request{Source: SourceDownload, Path: "/srv/incoming/a.txt"}, false, ""},
+		{Request{Source: SourceDownload, Path: "/etc/shadow", Roles: []string{"ops"}}, false, "secrets"},
+		{Request{Source: SourceDownload, Path: filepath.Join(dir, "etc/shadow"), Roles: []string{"ops"}}, false, "secrets"},
+		{Request{Source: SourceDownload, Path: "/var/log/app.log", Roles: []string{"ops"}}, true, "ops"},
+		{Request{Source: SourceDownload, Path: "/usr/bin/ls"}, false, ""},
+		{Request{Command: "ls"}, true, "rule 1"},
+	} {
+		decision := Evaluate(test.req)
+		if decision.Allowed != test.allowed || decision.Rule != test.rule {
+			t.Errorf("%+v: %+v", test.req, decision)
+		}
+	}
+}
+
+func TestAllowedCommands(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	err := os.WriteFile(path, []byte(`{
+		"rules": [
+			{"action": "deny", "executables": ["cat"]},
+			{"action": "deny", "roles": ["guest"], "executables": ["ls"]},
+			{"action": "allow", "executables": ["ls", "cat", "uptime"]},
+			{"action": "allow", "roles": ["ops"], "executables": ["make"]}
+		]
+	}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Load(path); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { current = nil }()
+
+	if got := strings.Join(AllowedCommands(), " "); got != "ls uptime" {
+		t.Errorf("AllowedCommands() = %q, want \"ls uptime\"", got)
+	}
+}
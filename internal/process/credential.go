@@ -0,0 +1,141 @@
+package process
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/adaptive-scale/webshell/internal/config"
+)
+
+// Credential identifies the user spawned processes run as
+type Credential struct {
+	Username string
+	UID      uint32
+	GID      uint32
+	Groups   []uint32
+	Home     string
+}
+
+// LookupUser resolves a "user[:group]" spec, where both parts may be names
+// or numeric IDs. The user's supplementary groups are included. An empty spec
+// returns nil, meaning processes run as the server user.
+func LookupUser(spec string) (*Credential, error) {
+	if spec == "" {
+		return nil, nil
+	}
+
+	userPart, groupPart, _ := strings.Cut(spec, ":")
+
+	u, err := user.Lookup(userPart)
+	if err != nil {
+		if _, numErr := strconv.ParseUint(userPart, 10, 32); numErr != nil {
+			return nil, fmt.Errorf("unknown user %q: %w", userPart, err)
+		}
+		if u, err = user.LookupId(userPart); err != nil {
+			return nil, fmt.Errorf("unknown user id %q: %w", userPart, err)
+		}
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("user %q has non-numeric uid %q", u.Username, u.Uid)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("user %q has non-numeric gid %q", u.Username, u.Gid)
+	}
+
+	if groupPart != "" {
+		g, err := user.LookupGroup(groupPart)
+		if err != nil {
+			if g, err = user.LookupGroupId(groupPart); err != nil {
+				return nil, fmt.Errorf("unknown group %q: %w", groupPart, err)
+			}
+		}
+		if gid, err = strconv.ParseUint(g.Gid, 10, 32); err != nil {
+			return nil, fmt.Errorf("group %q has non-numeric gid %q", g.Name, g.Gid)
+		}
+	}
+
+	cred := &Credential{
+		Username: u.Username,
+		UID:      uint32(uid),
+		GID:      uint32(gid),
+		Home:     u.HomeDir,
+	}
+
+	groupIDs, err := u.GroupIds()
+	if err == nil {
+		for _, id := range groupIDs {
+			if n, err := strconv.ParseUint(id, 10, 32); err == nil {
+				cred.Groups = append(cred.Groups, uint32(n))
+			}
+		}
+	}
+
+	return cred, nil
+}
+
+// String returns the credential in user(uid):gid form for logs
+func (c *Credential) String() string {
+	return fmt.Sprintf("%s(%d):%d", c.Username, c.UID, c.GID)
+}
+
+// runAsEnv lists the server variables passed on to processes run as another
+// user; everything else is dropped
+var runAsEnv = []string{"PATH", "LANG", "LANGUAGE", "TZ", "TERM"}
+
+// Environ returns the environment for processes run as cred. Server settings
+// holding secrets are always removed. A nil credential keeps the rest of the
+// server's environment, otherwise only PATH, locale and timezone variables
+// are kept and HOME, USER and LOGNAME point at the credential's user.
+func Environ(cred *Credential) []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		key, _, _ := strings.Cut(kv, "=")
+		if config.IsSecretEnv(key) {
+			continue
+		}
+		if cred != nil && !keepForUser(key) {
+			continue
+		}
+		env = append(env, kv)
+	}
+	if cred == nil {
+		return env
+	}
+	return append(env,
+		"HOME="+cred.Home,
+		"USER="+cred.Username,
+		"LOGNAME="+cred.Username,
+	)
+}
+
+// keepForUser reports whether key is passed on to processes run as another user
+func keepForUser(key string) bool {
+	if strings.HasPrefix(key, "LC_") {
+		return true
+	}
+	for _, name := range runAsEnv {
+		if key == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply makes cmd run as the credential's user. Unless cmd already has an
+// environment it gets Environ(cred). A nil credential runs cmd as the server
+// user.
+func Apply(cmd *exec.Cmd, cred *Credential) {
+	if cmd.Env == nil {
+		cmd.Env = Environ(cred)
+	}
+	if cred != nil {
+		setCredential(cmd, cred)
+	}
+}
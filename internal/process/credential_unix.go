@@ -0,0 +1,34 @@
+//go:build !windows
+
+package process
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setCredential sets the uid, gid and supplementary groups of cmd
+func setCredential(cmd *exec.Cmd, cred *Credential) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{
+		Uid:    cred.UID,
+		Gid:    cred.GID,
+		Groups: cred.Groups,
+	}
+}
+
+// CheckPrivilege verifies the server can spawn processes as cred, which
+// requires root unless cred is the server's own user
+func CheckPrivilege(cred *Credential) error {
+	if cred == nil {
+		return nil
+	}
+	if os.Geteuid() == 0 || (uint32(os.Geteuid()) == cred.UID && uint32(os.Getegid()) == cred.GID) {
+		return nil
+	}
+	return fmt.Errorf("cannot run as %s: server is not running as root", cred)
+}
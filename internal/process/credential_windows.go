@@ -0,0 +1,19 @@
+//go:build windows
+
+package process
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// setCredential is not supported on Windows
+func setCredential(cmd *exec.Cmd, cred *Credential) {}
+
+// CheckPrivilege always fails on Windows, where switching users is not supported
+func CheckPrivilege(cred *Credential) error {
+	if cred == nil {
+		return nil
+	}
+	return errors.New("running processes as another user is not supported on Windows")
+}
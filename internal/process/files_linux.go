@@ -0,0 +1,75 @@
+package process
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// AsUser runs fn with file access checked as cred's user and groups, so fn
+// can only open what that user could and files it creates belong to it. Only
+// the calling thread switches, using the filesystem uid and gid, and it is
+// locked to the goroutine until fn returns. A nil credential, or the server's
+// own user, runs fn unchanged.
+func AsUser(cred *Credential, fn func() error) error {
+	if cred == nil || (uint32(os.Geteuid()) == cred.UID && uint32(os.Getegid()) == cred.GID) {
+		return fn()
+	}
+	if err := CheckPrivilege(cred); err != nil {
+		return err
+	}
+
+	saved, err := syscall.Getgroups()
+	if err != nil {
+		return err
+	}
+
+	runtime.LockOSThread()
+	if err := setFileUser(cred.UID, cred.GID, cred.Groups); err != nil {
+		restoreFileUser(saved)
+		return err
+	}
+	defer restoreFileUser(saved)
+	return fn()
+}
+
+// restoreFileUser switches the thread back to the server's user. If that
+// fails the thread stays locked, so it ends with the goroutine instead of
+// running other code with the wrong identity.
+func restoreFileUser(groups []int) {
+	gids := make([]uint32, len(groups))
+	for i, g := range groups {
+		gids[i] = uint32(g)
+	}
+	if err := setFileUser(uint32(os.Geteuid()), uint32(os.Getegid()), gids); err != nil {
+		return
+	}
+	runtime.UnlockOSThread()
+}
+
+// setFileUser sets the supplementary groups, filesystem gid and filesystem
+// uid of the calling thread. The raw syscalls are used because the syscall
+// package applies setgroups to every thread.
+func setFileUser(uid, gid uint32, groups []uint32) error {
+	var list unsafe.Pointer
+	if len(groups) > 0 {
+		list = unsafe.Pointer(&groups[0])
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_SETGROUPS, uintptr(len(groups)), uintptr(list), 0); errno != 0 {
+		return fmt.Errorf("setgroups: %w", errno)
+	}
+
+	// setfsuid and setfsgid never fail; they return the previous ID, so a
+	// second call with -1 reads back what took effect
+	syscall.RawSyscall(syscall.SYS_SETFSGID, uintptr(gid), 0, 0)
+	if got, _, _ := syscall.RawSyscall(syscall.SYS_SETFSGID, ^uintptr(0), 0, 0); uint32(got) != gid {
+		return fmt.Errorf("setfsgid %d: still %d", gid, got)
+	}
+	syscall.RawSyscall(syscall.SYS_SETFSUID, uintptr(uid), 0, 0)
+	if got, _, _ := syscall.RawSyscall(syscall.SYS_SETFSUID, ^uintptr(0), 0, 0); uint32(got) != uid {
+		return fmt.Errorf("setfsuid %d: still %d", uid, got)
+	}
+	return nil
+}
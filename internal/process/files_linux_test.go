@@ -0,0 +1,36 @@
+package process
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAsUserChecksAccessAsUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("switching users requires root")
+	}
+	cred, err := LookupUser("nobody")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	// A file only root can read
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err = AsUser(cred, func() error {
+		_, err := os.ReadFile(path)
+		return err
+	})
+	if !os.IsPermission(err) {
+		t.Errorf("read as nobody: err = %v, want permission denied", err)
+	}
+
+	// The thread is back to root afterwards
+	if _, err := os.ReadFile(path); err != nil {
+		t.Errorf("read after AsUser: %v", err)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package process
+
+import (
+	"errors"
+	"os"
+)
+
+// AsUser runs fn as the server user. Checking file access as another user is
+// only supported on Linux, so any other credential fails.
+func AsUser(cred *Credential, fn func() error) error {
+	if cred == nil || (uint32(os.Geteuid()) == cred.UID && uint32(os.Getegid()) == cred.GID) {
+		return fn()
+	}
+	return errors.New("file access as another user is only supported on Linux")
+}
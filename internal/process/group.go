@@ -0,0 +1,16 @@
+package process
+
+import (
+	"time"
+)
+
+// pollInterval is how often Terminate checks whether processes have exited
+const pollInterval = 20 * time.Millisecond
+
+// waitGone polls until alive reports false or the grace period elapses
+func waitGone(alive func() bool, grace time.Duration) {
+	deadline := time.Now().Add(grace)
+	for alive() && time.Now().Before(deadline) {
+		time.Sleep(pollInterval)
+	}
+}
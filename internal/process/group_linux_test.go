@@ -0,0 +1,123 @@
+package process
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startGroup starts script with sh in a new process group, or a new session
+// when session is set, and waits for it to spawn its children. The returned
+// channel is closed once the leader has been reaped; startGroup owns the only
+// call to Wait.
+func startGroup(t *testing.T, script string, session bool) (*exec.Cmd, <-chan struct{}) {
+	t.Helper()
+	cmd := exec.Command("/bin/sh", "-c", script)
+	if session {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	} else {
+		SetGroup(cmd)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(done)
+	}()
+	t.Cleanup(func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(descendants(cmd.Process.Pid)) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("children did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cmd, done
+}
+
+func assertNoSurvivors(t *testing.T, pid int) {
+	t.Helper()
+	if members := descendants(pid); len(members) > 0 {
+		t.Fatalf("%d processes survived: %v", len(members), members)
+	}
+}
+
+func TestTerminateKillsBackgroundChildren(t *testing.T) {
+	cmd, _ := startGroup(t, "sleep 999 & sleep 999 & wait", false)
+
+	Terminate(cmd.Process.Pid, time.Second)
+	assertNoSurvivors(t, cmd.Process.Pid)
+}
+
+func TestTerminateEscalatesToKill(t *testing.T) {
+	cmd, _ := startGroup(t, "trap '' HUP TERM; sh -c \"trap '' HUP TERM; sleep 999\" & sleep 999 & wait", false)
+
+	start := time.Now()
+	Terminate(cmd.Process.Pid, 100*time.Millisecond)
+	assertNoSurvivors(t, cmd.Process.Pid)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Terminate took %v", elapsed)
+	}
+}
+
+func TestTerminateKillsJobsInOtherGroups(t *testing.T) {
+	// With job control on, each background job gets its own process group in
+	// the shell's session, as in an interactive terminal
+	cmd, _ := startGroup(t, "set -m; sleep 999 & sleep 999 & wait", true)
+
+	Terminate(cmd.Process.Pid, time.Second)
+	assertNoSurvivors(t, cmd.Process.Pid)
+}
+
+func TestTerminateAfterLeaderExited(t *testing.T) {
+	cmd, done := startGroup(t, "sleep 999 & sleep 999 & sleep 999 & exit 0", false)
+	// The children have been spawned so the leader is done
+	<-done
+	if len(descendants(cmd.Process.Pid)) == 0 {
+		t.Fatalf("background children exited on their own")
+	}
+
+	Terminate(cmd.Process.Pid, time.Second)
+	assertNoSurvivors(t, cmd.Process.Pid)
+}
+
+func TestWaitEndsGroupBeforeReaping(t *testing.T) {
+	cmd := exec.Command("/bin/sh", "-c", "sleep 999 & sleep 999 & exit 3")
+	SetGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	t.Cleanup(func() { syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) })
+
+	err := Wait(context.Background(), cmd, time.Second)
+	if cmd.ProcessState == nil || cmd.ProcessState.ExitCode() != 3 {
+		t.Fatalf("Wait = %v, state %v", err, cmd.ProcessState)
+	}
+	assertNoSurvivors(t, cmd.Process.Pid)
+}
+
+func TestWaitStopsOnCancel(t *testing.T) {
+	cmd := exec.Command("/bin/sh", "-c", "sleep 999 & sleep 999")
+	SetGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	t.Cleanup(func() { syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	Wait(ctx, cmd, time.Second)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Wait took %v", elapsed)
+	}
+	assertNoSurvivors(t, cmd.Process.Pid)
+}
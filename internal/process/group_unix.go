@@ -0,0 +1,86 @@
+//go:build !windows
+
+package process
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// SetGroup starts cmd in its own process group so Terminate can reach all of
+// its descendants
+func SetGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// Terminate ends the process group led by pid together with every process in
+// the session pid leads (background jobs of an interactive shell live in their
+// own groups). Processes get SIGHUP, then SIGTERM, then SIGKILL, with the grace
+// period in between. Killed children of the server other than pid itself,
+// which belongs to its exec.Cmd, are reaped so they do not linger as zombies.
+func Terminate(pid int, grace time.Duration) {
+	var killed []int
+	for _, sig := range []syscall.Signal{syscall.SIGHUP, syscall.SIGTERM, syscall.SIGKILL} {
+		members := descendants(pid)
+		if len(members) == 0 && syscall.Kill(-pid, 0) != nil {
+			break
+		}
+
+		syscall.Kill(-pid, sig)
+		for _, p := range members {
+			syscall.Kill(p.pid, sig)
+		}
+		killed = append(killed, childPIDs(members, pid)...)
+
+		if sig == syscall.SIGKILL {
+			break
+		}
+		waitGone(func() bool { return groupAlive(pid) }, grace)
+	}
+
+	for _, p := range unique(killed) {
+		go func(p int) {
+			var status syscall.WaitStatus
+			syscall.Wait4(p, &status, 0, nil)
+		}(p)
+	}
+}
+
+// groupAlive reports whether any process of the group or session led by pid
+// is still running
+func groupAlive(pid int) bool {
+	if members := descendants(pid); members != nil {
+		return len(members) > 0
+	}
+	return syscall.Kill(-pid, 0) == nil
+}
+
+// childPIDs returns the members that are direct children of the server,
+// excluding the leader
+func childPIDs(members []member, leader int) []int {
+	self := os.Getpid()
+	var pids []int
+	for _, p := range members {
+		if p.ppid == self && p.pid != leader {
+			pids = append(pids, p.pid)
+		}
+	}
+	return pids
+}
+
+func unique(pids []int) []int {
+	seen := make(map[int]bool)
+	var out []int
+	for _, p := range pids {
+		if !seen[p] {
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,19 @@
+//go:build windows
+
+package process
+
+import (
+	"os"
+	"os/exec"
+	"time"
+)
+
+// SetGroup is a no-op on Windows
+func SetGroup(cmd *exec.Cmd) {}
+
+// Terminate kills the process; Windows has no process groups to signal
+func Terminate(pid int, grace time.Duration) {
+	if p, err := os.FindProcess(pid); err == nil {
+		p.Kill()
+	}
+}
@@ -0,0 +1,52 @@
+package process
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// member is a running process found in /proc
+type member struct {
+	pid  int
+	ppid int
+}
+
+// descendants returns the live (non-zombie) processes whose process group or
+// session is led by leader
+func descendants(leader int) []member {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+
+	members := []member{}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile("/proc/" + entry.Name() + "/stat")
+		if err != nil {
+			continue
+		}
+		// The command name may contain spaces, fields start after the last ')'
+		stat := string(data)
+		i := strings.LastIndexByte(stat, ')')
+		if i < 0 {
+			continue
+		}
+		// state ppid pgrp session ...
+		fields := strings.Fields(stat[i+1:])
+		if len(fields) < 4 || fields[0] == "Z" || fields[0] == "X" {
+			continue
+		}
+		ppid, _ := strconv.Atoi(fields[1])
+		pgrp, _ := strconv.Atoi(fields[2])
+		session, _ := strconv.Atoi(fields[3])
+		if pgrp == leader || session == leader {
+			members = append(members, member{pid: pid, ppid: ppid})
+		}
+	}
+	return members
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !windows
+
+package process
+
+// member is a running process
+type member struct {
+	pid  int
+	ppid int
+}
+
+// descendants is not available without /proc; Terminate falls back to
+// signalling the process group
+func descendants(leader int) []member {
+	return nil
+}
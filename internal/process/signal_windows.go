@@ -0,0 +1,12 @@
+//go:build windows
+
+package process
+
+import (
+	"os"
+)
+
+// ExitSignal always returns "" as Windows processes are not killed by signals
+func ExitSignal(state *os.ProcessState) string {
+	return ""
+}
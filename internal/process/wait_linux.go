@@ -0,0 +1,46 @@
+package process
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Wait waits until cmd exits or ctx is done, ends the rest of its process
+// group and session with Terminate, then reaps cmd and returns the result of
+// cmd.Wait. The leader is only reaped after the group has been signalled, so
+// its PID, which is also the group and session ID, cannot have been reused.
+func Wait(ctx context.Context, cmd *exec.Cmd, grace time.Duration) error {
+	pid := cmd.Process.Pid
+	exited := make(chan struct{})
+	go func() {
+		waitExited(pid)
+		close(exited)
+	}()
+
+	select {
+	case <-exited:
+	case <-ctx.Done():
+	}
+	Terminate(pid, grace)
+	return cmd.Wait()
+}
+
+// waitExited blocks until the process exits, leaving it a zombie for Wait to
+// reap. It also returns if the process has already been reaped.
+func waitExited(pid int) {
+	const (
+		pPID    = 1          // P_PID
+		wNoWait = 0x01000000 // WNOWAIT
+	)
+	var info [128]byte // siginfo_t
+	for {
+		_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pPID, uintptr(pid),
+			uintptr(unsafe.Pointer(&info[0])), syscall.WEXITED|wNoWait, 0, 0)
+		if errno != syscall.EINTR {
+			return
+		}
+	}
+}
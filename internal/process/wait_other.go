@@ -0,0 +1,29 @@
+//go:build !linux
+
+package process
+
+import (
+	"context"
+	"os/exec"
+	"time"
+)
+
+// Wait waits until cmd exits or ctx is done, ends the rest of its process
+// group with Terminate and returns the result of cmd.Wait. Without a way to
+// wait for an exit without reaping, the leader is reaped before its group is
+// signalled when it exits on its own.
+func Wait(ctx context.Context, cmd *exec.Cmd, grace time.Duration) error {
+	waited := make(chan error, 1)
+	go func() {
+		waited <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-waited:
+		Terminate(cmd.Process.Pid, grace)
+		return err
+	case <-ctx.Done():
+		Terminate(cmd.Process.Pid, grace)
+		return <-waited
+	}
+}
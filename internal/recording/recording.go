@@ -0,0 +1,351 @@
+// Package recording writes terminal sessions to asciicast v2 files and lists,
+// serves and prunes the stored recordings.
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/adaptive-scale/webshell/internal/config"
+)
+
+// Extension is the file extension used for asciicast recordings
+const Extension = ".cast"
+
+// ErrNotFound is returned when a recording does not exist
+var ErrNotFound = errors.New("recording not found")
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// active tracks recordings that are still being written so retention skips them
+var active = struct {
+	sync.Mutex
+	ids map[string]bool
+}{ids: make(map[string]bool)}
+
+// Header is the first line of an asciicast v2 file
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+	// Owner is the principal whose session was recorded, an extension to
+	// the asciicast format that players ignore
+	Owner string `json:"owner,omitempty"`
+}
+
+// Recorder writes terminal events to an asciicast v2 file
+type Recorder struct {
+	id          string
+	mu          sync.Mutex
+	file        *os.File
+	start       time.Time
+	recordInput bool
+	closed      bool
+
+	// Incomplete UTF-8 sequences carried over to the next event
+	pendingOutput []byte
+	pendingInput  []byte
+}
+
+// Start creates a new recording for the given terminal session, owned by the
+// session's owner
+func Start(id, owner string, width, height int, title string, env map[string]string) (*Recorder, error) {
+	if !validID.MatchString(id) {
+		return nil, fmt.Errorf("invalid recording id %q", id)
+	}
+
+	dir := config.GetRecordingDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, id+Extension), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &Recorder{
+		id:          id,
+		file:        file,
+		start:       time.Now(),
+		recordInput: config.GetRecordInput(),
+	}
+
+	header, err := json.Marshal(Header{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: rec.start.Unix(),
+		Title:     title,
+		Env:       env,
+		Owner:     owner,
+	})
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Write(append(header, '\n')); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	active.Lock()
+	active.ids[id] = true
+	active.Unlock()
+
+	// Make room for the new recording
+	go Prune()
+
+	return rec, nil
+}
+
+// Output records data written by the terminal
+func (rec *Recorder) Output(p []byte) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.pendingOutput = rec.writeData("o", rec.pendingOutput, p)
+}
+
+// Input records data typed by the user, if input recording is enabled
+func (rec *Recorder) Input(p []byte) {
+	if !rec.recordInput {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.pendingInput = rec.writeData("i", rec.pendingInput, p)
+}
+
+// Resize records a terminal size change
+func (rec *Recorder) Resize(width, height int) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.writeEvent("r", fmt.Sprintf("%dx%d", width, height))
+}
+
+// Close finishes the recording
+func (rec *Recorder) Close() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	// Flush whatever is left, even if it is not valid UTF-8
+	if len(rec.pendingOutput) > 0 {
+		rec.writeEvent("o", string(rec.pendingOutput))
+		rec.pendingOutput = nil
+	}
+
+	rec.closed = true
+
+	active.Lock()
+	delete(active.ids, rec.id)
+	active.Unlock()
+
+	return rec.file.Close()
+}
+
+// writeData writes an event for pending+p, holding back a trailing incomplete
+// UTF-8 sequence so multi-byte characters split across reads stay intact
+func (rec *Recorder) writeData(code string, pending, p []byte) []byte {
+	data := append(pending, p...)
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	if cut > 0 {
+		rec.writeEvent(code, string(data[:cut]))
+	}
+	return append([]byte(nil), data[cut:]...)
+}
+
+// writeEvent appends a single [time, code, data] line to the file
+func (rec *Recorder) writeEvent(code, data string) {
+	if rec.closed {
+		return
+	}
+	elapsed := time.Since(rec.start).Seconds()
+	line, err := json.Marshal([]interface{}{elapsed, code, data})
+	if err != nil {
+		return
+	}
+	if _, err := rec.file.Write(append(line, '\n')); err != nil {
+		log.Printf("Failed to write recording %s: %v", rec.id, err)
+	}
+}
+
+// Info describes a stored recording
+type Info struct {
+	ID         string    `json:"id"`
+	Owner      string    `json:"owner,omitempty"`
+	Size       int64     `json:"size"`
+	Width      int       `json:"width"`
+	Height     int       `json:"height"`
+	StartedAt  time.Time `json:"started_at"`
+	ModifiedAt time.Time `json:"modified_at"`
+	Active     bool      `json:"active"`
+}
+
+// List returns all stored recordings, newest first
+func List() ([]Info, error) {
+	entries, err := os.ReadDir(config.GetRecordingDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Info{}, nil
+		}
+		return nil, err
+	}
+
+	active.Lock()
+	defer active.Unlock()
+
+	infos := make([]Info, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, Extension) {
+			continue
+		}
+		fi, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, describe(strings.TrimSuffix(name, Extension), fi))
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModifiedAt.After(infos[j].ModifiedAt)
+	})
+	return infos, nil
+}
+
+// Get returns the Info of a stored recording
+func Get(id string) (Info, error) {
+	if !validID.MatchString(id) {
+		return Info{}, ErrNotFound
+	}
+	fi, err := os.Stat(filepath.Join(config.GetRecordingDir(), id+Extension))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Info{}, ErrNotFound
+		}
+		return Info{}, err
+	}
+
+	active.Lock()
+	defer active.Unlock()
+	return describe(id, fi), nil
+}
+
+// describe builds the Info of a recording from its file and header; active
+// must be locked
+func describe(id string, fi os.FileInfo) Info {
+	info := Info{
+		ID:         id,
+		Size:       fi.Size(),
+		ModifiedAt: fi.ModTime().UTC(),
+		Active:     active.ids[id],
+	}
+	if header, err := readHeader(filepath.Join(config.GetRecordingDir(), id+Extension)); err == nil {
+		info.Owner = header.Owner
+		info.Width = header.Width
+		info.Height = header.Height
+		info.StartedAt = time.Unix(header.Timestamp, 0).UTC()
+	}
+	return info
+}
+
+// Open opens a stored recording for reading
+func Open(id string) (*os.File, error) {
+	if !validID.MatchString(id) {
+		return nil, ErrNotFound
+	}
+	file, err := os.Open(filepath.Join(config.GetRecordingDir(), id+Extension))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+// Prune applies the retention policy, deleting recordings that are too old
+// or exceed the maximum count. Recordings still being written are kept.
+func Prune() {
+	maxAge, maxFiles := config.GetRecordingRetention()
+	if maxAge <= 0 && maxFiles <= 0 {
+		return
+	}
+
+	infos, err := List()
+	if err != nil {
+		log.Printf("Failed to list recordings for retention: %v", err)
+		return
+	}
+
+	kept := 0
+	for _, info := range infos {
+		if info.Active {
+			kept++
+			continue
+		}
+		expired := maxAge > 0 && time.Since(info.ModifiedAt) > maxAge
+		overLimit := maxFiles > 0 && kept >= maxFiles
+		if !expired && !overLimit {
+			kept++
+			continue
+		}
+		path := filepath.Join(config.GetRecordingDir(), info.ID+Extension)
+		if err := os.Remove(path); err != nil {
+			log.Printf("Failed to remove recording %s: %v", info.ID, err)
+			continue
+		}
+		log.Printf("Removed recording %s (retention policy)", info.ID)
+	}
+}
+
+// RunRetention prunes recordings now and then periodically in the background
+func RunRetention(interval time.Duration) {
+	Prune()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			Prune()
+		}
+	}()
+}
+
+// readHeader reads the asciicast header line of a recording
+func readHeader(path string) (Header, error) {
+	var header Header
+	file, err := os.Open(path)
+	if err != nil {
+		return header, err
+	}
+	defer file.Close()
+
+	line, err := bufio.NewReader(file).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return header, err
+	}
+	err = json.Unmarshal(line, &header)
+	return header, err
+}
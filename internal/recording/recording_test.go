@@ -0,0 +1,135 @@
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adaptive-scale/webshell/internal/config"
+)
+
+func TestMain(m *testing.M) {
+	// Start prunes in the background, so the settings are made once for all
+	// tests: recordings older than an hour are removed, and all but the 2
+	// newest
+	dir, err := os.MkdirTemp("", "recordings")
+	if err != nil {
+		panic(err)
+	}
+	config.SetRecordingDir(dir)
+	config.SetRecordingRetention(time.Hour, 2)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// removeAfter deletes the recording with the given ID when the test ends
+func removeAfter(t *testing.T, id string) {
+	t.Cleanup(func() { os.Remove(filepath.Join(config.GetRecordingDir(), id+Extension)) })
+}
+
+// writeRecording stores a recording with the given ID that was last written age ago
+func writeRecording(t *testing.T, id string, age time.Duration) {
+	t.Helper()
+	removeAfter(t, id)
+	path := filepath.Join(config.GetRecordingDir(), id+Extension)
+	if err := os.WriteFile(path, []byte(`{"version":2,"width":80,"height":24}`+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Now().Add(-age)
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	active.Lock()
+	active.ids["live"] = true
+	active.Unlock()
+	defer func() {
+		active.Lock()
+		delete(active.ids, "live")
+		active.Unlock()
+	}()
+	writeRecording(t, "live", 3*time.Hour)
+	writeRecording(t, "newest", 0)
+	writeRecording(t, "newer", time.Minute)
+	writeRecording(t, "third", 2*time.Minute)
+	writeRecording(t, "old", 2*time.Hour)
+
+	// "third" is over the count and "old" too old; "live" is too old as well
+	// but still being written
+	Prune()
+
+	infos, err := List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kept []string
+	for _, info := range infos {
+		kept = append(kept, info.ID)
+	}
+	if len(kept) != 3 || kept[0] != "newest" || kept[1] != "newer" || kept[2] != "live" {
+		t.Fatalf("kept %v, want [newest newer live]", kept)
+	}
+}
+
+func TestRecorderHoldsBackSplitUTF8(t *testing.T) {
+	removeAfter(t, "utf8")
+	rec, err := Start("utf8", "token:alice", 80, 24, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "é" split across two reads, then a truncated "€" left at the end
+	rec.Output([]byte("\xc3"))
+	rec.Output([]byte("\xa9!"))
+	rec.Output([]byte("a\xe2\x82"))
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := Open("utf8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // header
+	var events []string
+	for scanner.Scan() {
+		var event []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event[2].(string))
+	}
+	if len(events) != 3 || events[0] != "é!" || events[1] != "a" {
+		t.Fatalf("got events %q, want \"é!\", \"a\" and the flushed remainder", events)
+	}
+}
+
+func TestOwnerRoundTrip(t *testing.T) {
+	removeAfter(t, "owned")
+	rec, err := Start("owned", "token:alice", 100, 30, "title", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := Get("owned"); err != nil || !info.Active {
+		t.Fatalf("Get while recording = %+v, %v", info, err)
+	}
+	rec.Close()
+
+	info, err := Get("owned")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Owner != "token:alice" || info.Width != 100 || info.Height != 30 || info.Active {
+		t.Fatalf("Get = %+v", info)
+	}
+	if _, err := Get("missing"); err != ErrNotFound {
+		t.Fatalf("Get(missing) = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,31 @@
+// Package size parses human-readable byte sizes used by settings and
+// request parameters.
+package size
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Parse converts a size such as "4096", "512M" or "2G" to a number of bytes.
+// K, M, G and T suffixes are binary multiples and may be followed by B.
+func Parse(s string) (int64, error) {
+	multiplier := int64(1)
+	number := strings.TrimSuffix(strings.ToUpper(s), "B")
+	for suffix, m := range map[string]int64{"K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40} {
+		if strings.HasSuffix(number, suffix) {
+			number, multiplier = strings.TrimSuffix(number, suffix), m
+			break
+		}
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q, expected bytes or a K, M, G or T suffix", s)
+	}
+	if n > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("invalid size %q, too large", s)
+	}
+	return n * multiplier, nil
+}
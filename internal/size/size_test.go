@@ -0,0 +1,29 @@
+package size
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want int64
+	}{
+		{"4096", 4096},
+		{"512k", 512 << 10},
+		{"10M", 10 << 20},
+		{"2GB", 2 << 30},
+		{"1T", 1 << 40},
+		{"0", 0},
+		{"-1M", 0},
+		{"max", 0},
+		{"1.5G", 0},
+		{"8388607T", 8388607 << 40},
+		{"8388608T", 0},
+		{"9999999999G", 0},
+		{"9223372036854775807", 1<<63 - 1},
+	} {
+		got, err := Parse(test.in)
+		if got != test.want || (err == nil) != (test.want > 0) {
+			t.Errorf("Parse(%q) = %d, %v", test.in, got, err)
+		}
+	}
+}
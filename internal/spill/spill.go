@@ -0,0 +1,188 @@
+// Package spill keeps the full output of commands whose response had to be
+// truncated in temporary files, so it can still be downloaded for a while.
+package spill
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/adaptive-scale/webshell/internal/config"
+)
+
+// ErrNotFound is returned for unknown or expired output
+var ErrNotFound = errors.New("output not found")
+
+var validID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// Info describes a kept output file
+type Info struct {
+	ID string `json:"id"`
+	// URL is where the output can be downloaded, set by the handler
+	URL   string `json:"url,omitempty"`
+	Bytes int64  `json:"bytes"`
+	// Complete is false when the output exceeded the spill limit, so only
+	// its start was written
+	Complete  bool      `json:"complete"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// File receives the full output of a command. A nil *File discards it.
+type File struct {
+	id      string
+	file    *os.File
+	written int64
+	limit   int64
+	full    bool
+	err     error
+}
+
+// Create starts an output file for owner, a Principal, in the configured
+// output directory. It returns nil if no directory is configured.
+func Create(owner string) (*File, error) {
+	dir := config.GetOutputDir()
+	if dir == "" {
+		return nil, nil
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	id := hex.EncodeToString(b)
+	if err := os.WriteFile(ownerPath(id), []byte(owner), 0600); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path(id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		os.Remove(ownerPath(id))
+		return nil, err
+	}
+	return &File{id: id, file: file, limit: config.GetOutputSpillMax()}, nil
+}
+
+// Write appends p to the file. It never fails, so capturing the output goes
+// on: output past the spill limit is dropped and a write error stops writing
+// and is reported by Keep.
+func (f *File) Write(p []byte) (int, error) {
+	if f == nil || f.err != nil || f.full {
+		return len(p), nil
+	}
+	data := p
+	if f.limit > 0 && f.written+int64(len(data)) > f.limit {
+		data, f.full = data[:f.limit-f.written], true
+	}
+	n, err := f.file.Write(data)
+	f.written += int64(n)
+	f.err = err
+	return len(p), nil
+}
+
+// Keep closes the file and keeps it for the configured retention
+func (f *File) Keep() (*Info, error) {
+	if f == nil {
+		return nil, nil
+	}
+	err := f.file.Close()
+	if f.err != nil {
+		err = f.err
+	}
+	if err != nil {
+		remove(f.id)
+		return nil, err
+	}
+	retention := config.GetOutputRetention()
+	expire(f.id, retention)
+	return &Info{
+		ID:        f.id,
+		Bytes:     f.written,
+		Complete:  !f.full,
+		ExpiresAt: time.Now().Add(retention).UTC(),
+	}, nil
+}
+
+// Discard closes and removes the file
+func (f *File) Discard() {
+	if f == nil {
+		return
+	}
+	f.file.Close()
+	remove(f.id)
+}
+
+// Open opens the kept output with the given ID and returns its owner, empty
+// for output kept by a version that did not record owners
+func Open(id string) (*os.File, string, error) {
+	if config.GetOutputDir() == "" || !validID.MatchString(id) {
+		return nil, "", ErrNotFound
+	}
+	file, err := os.Open(path(id))
+	if os.IsNotExist(err) {
+		return nil, "", ErrNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	owner, err := os.ReadFile(ownerPath(id))
+	if err != nil && !os.IsNotExist(err) {
+		file.Close()
+		return nil, "", err
+	}
+	return file, string(owner), nil
+}
+
+// Load prepares the output directory. Files kept by an earlier run are
+// removed once their retention has passed.
+func Load() error {
+	dir := config.GetOutputDir()
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.out"))
+	if err != nil {
+		return err
+	}
+	retention := config.GetOutputRetention()
+	for _, p := range paths {
+		id := strings.TrimSuffix(filepath.Base(p), ".out")
+		info, err := os.Stat(p)
+		if err != nil || !validID.MatchString(id) {
+			continue
+		}
+		expire(id, retention-time.Since(info.ModTime()))
+	}
+	return nil
+}
+
+// expire removes the output with the given ID after d
+func expire(id string, d time.Duration) {
+	time.AfterFunc(d, func() {
+		if err := remove(id); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove output %s: %v", id, err)
+		}
+	})
+}
+
+// remove deletes the output with the given ID and its owner file
+func remove(id string) error {
+	os.Remove(ownerPath(id))
+	return os.Remove(path(id))
+}
+
+func path(id string) string {
+	return filepath.Join(config.GetOutputDir(), id+".out")
+}
+
+// ownerPath is the file holding the Principal the output belongs to
+func ownerPath(id string) string {
+	return filepath.Join(config.GetOutputDir(), id+".owner")
+}
@@ -0,0 +1,449 @@
+package templates
+
+import (
+	"html/template"
+)
+
+// PlaybackTemplate is the HTML template for the recording playback page
+const PlaybackTemplate = `<!DOCTYPE html>
+<html>
+<head>
+    <title>SSH Fun - Recording {{.ID}}</title>
+    <link rel="icon" type="image/svg+xml" href="data:image/svg+xml,%3Csvg xmlns='http://www.w3.org/2000/svg' viewBox='0 0 24 24' fill='%2328a745'%3E%3Crect x='2' y='4' width='20' height='16' rx='2' fill='%2328a745'/%3E%3Cpath d='M6 8h12M6 12h8M6 16h10' stroke='white' stroke-width='1.5' stroke-linecap='round'/%3E%3C/svg%3E">
+    <link rel="preconnect" href="https://fonts.googleapis.com">
+    <link rel="preconnect" href="https://fonts.gstatic.com" crossorigin>
+    <link href="https://fonts.googleapis.com/css2?family=Open+Sans:wght@400;600;700&display=swap" rel="stylesheet">
+    <script src="https://cdn.jsdelivr.net/npm/xterm@5.3.0/lib/xterm.min.js"></script>
+    <link rel="stylesheet" href="https://cdn.jsdelivr.net/npm/xterm@5.3.0/css/xterm.min.css" />
+    <style>
+        body {
+            margin: 0;
+            padding: 0;
+            background-color: #000000;
+            font-family: 'Open Sans', sans-serif;
+            color: #ffffff;
+            height: 100vh;
+            display: flex;
+            flex-direction: column;
+        }
+        .header {
+            background-color: #ffffff;
+            padding: 10px 20px;
+            border-bottom: 2px solid #333333;
+        }
+        .header h1 {
+            color: #333333;
+            margin: 0;
+            font-size: 18px;
+            display: inline-block;
+            font-weight: 600;
+        }
+        .recording-id {
+            color: #007bff;
+            font-weight: bold;
+            font-size: 14px;
+            margin-left: 20px;
+            font-family: 'Courier New', monospace;
+        }
+        .header p {
+            color: #666666;
+            margin: 5px 0 0 0;
+            font-size: 12px;
+        }
+        .main {
+            flex: 1;
+            display: flex;
+            min-height: 0;
+        }
+        .terminal-container {
+            flex: 1;
+            padding: 10px;
+            overflow: auto;
+        }
+        .search-panel {
+            width: 300px;
+            background-color: #ffffff;
+            color: #333333;
+            border-left: 2px solid #333333;
+            padding: 10px;
+            display: flex;
+            flex-direction: column;
+            font-size: 12px;
+        }
+        .search-panel input {
+            padding: 5px 10px;
+            border: 1px solid #ccc;
+            border-radius: 3px;
+            font-size: 12px;
+        }
+        .search-results {
+            flex: 1;
+            overflow-y: auto;
+            margin-top: 10px;
+        }
+        .search-result {
+            padding: 6px;
+            border-bottom: 1px solid #eeeeee;
+            cursor: pointer;
+            font-family: 'Courier New', monospace;
+            white-space: pre-wrap;
+            word-break: break-all;
+        }
+        .search-result:hover {
+            background-color: #f0f0f0;
+        }
+        .search-result .time {
+            color: #007bff;
+            font-weight: bold;
+            margin-right: 6px;
+        }
+        .search-result mark {
+            background-color: #ffc107;
+        }
+        .controls {
+            background-color: #ffffff;
+            padding: 10px 20px;
+            border-top: 2px solid #333333;
+            display: flex;
+            align-items: center;
+            gap: 10px;
+            color: #333333;
+            font-size: 12px;
+        }
+        .btn {
+            background-color: #333333;
+            color: #ffffff;
+            border: none;
+            padding: 8px 16px;
+            border-radius: 3px;
+            cursor: pointer;
+            font-weight: bold;
+            font-size: 12px;
+            min-width: 70px;
+        }
+        .btn:hover {
+            background-color: #555555;
+        }
+        .btn:disabled {
+            background-color: #cccccc;
+            cursor: not-allowed;
+        }
+        #seek {
+            flex: 1;
+        }
+        .time-display {
+            font-family: 'Courier New', monospace;
+            min-width: 110px;
+            text-align: right;
+        }
+        .back-link a {
+            color: #007bff;
+            text-decoration: none;
+        }
+    </style>
+</head>
+<body>
+    <div class="header">
+        <h1>SSH - Session Playback</h1>
+        <span class="recording-id">{{.ID}}</span>
+        <p id="meta">Loading recording...</p>
+    </div>
+
+    <div class="main">
+        <div class="terminal-container" id="terminal"></div>
+        <div class="search-panel">
+            <input type="text" id="searchInput" placeholder="Search output...">
+            <div id="searchSummary" style="margin-top: 6px; color: #666666;"></div>
+            <div class="search-results" id="searchResults"></div>
+        </div>
+    </div>
+
+    <div class="controls">
+        <button class="btn" id="playBtn" onclick="togglePlay()" disabled>Play</button>
+        <select id="speedSelect" onchange="setSpeed(this.value)">
+            <option value="0.5">0.5x</option>
+            <option value="1" selected>1x</option>
+            <option value="2">2x</option>
+            <option value="4">4x</option>
+            <option value="8">8x</option>
+        </select>
+        <input type="range" id="seek" min="0" max="0" step="0.01" value="0" disabled>
+        <span class="time-display" id="timeDisplay">0:00 / 0:00</span>
+        <span class="back-link"><a href="../">All recordings</a></span>
+    </div>
+
+    <script>
+        const recordingId = {{.ID}};
+        const TOKEN_STORAGE_KEY = 'webshell_auth_token';
+
+        let term;
+        let header;
+        let events = [];
+        let duration = 0;
+        let position = 0;     // playback position in recording seconds
+        let nextEvent = 0;    // index of the next event to apply
+        let playing = false;
+        let speed = 1;
+        let lastTick = 0;
+
+        // Search index: output text with ANSI sequences stripped
+        let plainText = '';
+        let plainOffsets = [];   // [{offset, time}] for each output event
+
+        // Get token from localStorage or URL parameter
+        function getToken() {
+            const storedToken = localStorage.getItem(TOKEN_STORAGE_KEY);
+            if (storedToken) {
+                return storedToken;
+            }
+            const urlParams = new URLSearchParams(window.location.search);
+            return urlParams.get('token');
+        }
+
+        function formatTime(seconds) {
+            const m = Math.floor(seconds / 60);
+            const s = Math.floor(seconds % 60);
+            return m + ':' + (s < 10 ? '0' : '') + s;
+        }
+
+        function stripAnsi(text) {
+            return text
+                .replace(/\x1b\][^\x07\x1b]*(\x07|\x1b\\)/g, '')
+                .replace(/\x1b\[[0-9;?]*[ -\/]*[@-~]/g, '')
+                .replace(/\x1b[@-_]/g, '')
+                .replace(/\r/g, '');
+        }
+
+        // Load and parse the asciicast v2 file
+        async function loadRecording() {
+            const headers = {};
+            const token = getToken();
+            if (token) {
+                headers['X-Auth-Token'] = token;
+            }
+
+            const response = await fetch('../' + encodeURIComponent(recordingId), { headers: headers });
+            if (!response.ok) {
+                throw new Error(await response.text());
+            }
+
+            const lines = (await response.text()).split('\n').filter(line => line.trim());
+            header = JSON.parse(lines[0]);
+            for (let i = 1; i < lines.length; i++) {
+                try {
+                    const event = JSON.parse(lines[i]);
+                    if (Array.isArray(event) && event.length === 3) {
+                        events.push(event);
+                    }
+                } catch (e) {
+                    // Skip a truncated trailing line of an active recording
+                }
+            }
+            duration = events.length ? events[events.length - 1][0] : 0;
+
+            for (const event of events) {
+                if (event[1] === 'o') {
+                    plainOffsets.push({ offset: plainText.length, time: event[0] });
+                    plainText += stripAnsi(event[2]);
+                }
+            }
+        }
+
+        function initTerminal() {
+            term = new Terminal({
+                cols: header.width || 80,
+                rows: header.height || 24,
+                fontSize: 14,
+                fontFamily: 'Courier New, monospace',
+                disableStdin: true,
+                cursorBlink: false,
+                theme: {
+                    background: '#000000',
+                    foreground: '#ffffff'
+                }
+            });
+            term.open(document.getElementById('terminal'));
+        }
+
+        // Apply events up to the current position, batching output writes
+        function applyEvents() {
+            let output = '';
+            while (nextEvent < events.length && events[nextEvent][0] <= position) {
+                const event = events[nextEvent];
+                if (event[1] === 'o') {
+                    output += event[2];
+                } else if (event[1] === 'r') {
+                    if (output) {
+                        term.write(output);
+                        output = '';
+                    }
+                    const size = event[2].split('x');
+                    term.resize(parseInt(size[0], 10), parseInt(size[1], 10));
+                }
+                nextEvent++;
+            }
+            if (output) {
+                term.write(output);
+            }
+        }
+
+        function updateProgress() {
+            const seek = document.getElementById('seek');
+            seek.value = position;
+            document.getElementById('timeDisplay').textContent = formatTime(position) + ' / ' + formatTime(duration);
+        }
+
+        function tick(now) {
+            if (!playing) {
+                return;
+            }
+            position = Math.min(duration, position + (now - lastTick) / 1000 * speed);
+            lastTick = now;
+            applyEvents();
+            updateProgress();
+            if (position >= duration) {
+                setPlaying(false);
+                return;
+            }
+            requestAnimationFrame(tick);
+        }
+
+        function setPlaying(value) {
+            playing = value;
+            document.getElementById('playBtn').textContent = playing ? 'Pause' : 'Play';
+            if (playing) {
+                lastTick = performance.now();
+                requestAnimationFrame(tick);
+            }
+        }
+
+        function togglePlay() {
+            if (!playing && position >= duration) {
+                seekTo(0);
+            }
+            setPlaying(!playing);
+        }
+
+        function setSpeed(value) {
+            speed = parseFloat(value);
+        }
+
+        // Rebuild the screen at the given position
+        function seekTo(time) {
+            position = Math.max(0, Math.min(duration, time));
+            nextEvent = 0;
+            term.reset();
+            term.resize(header.width || 80, header.height || 24);
+            applyEvents();
+            updateProgress();
+        }
+
+        function escapeHtml(text) {
+            const div = document.createElement('div');
+            div.textContent = text;
+            return div.innerHTML;
+        }
+
+        // Find the time of the output event containing the given offset
+        function timeAtOffset(offset) {
+            let lo = 0, hi = plainOffsets.length - 1;
+            while (lo < hi) {
+                const mid = Math.ceil((lo + hi) / 2);
+                if (plainOffsets[mid].offset <= offset) {
+                    lo = mid;
+                } else {
+                    hi = mid - 1;
+                }
+            }
+            return plainOffsets.length ? plainOffsets[lo].time : 0;
+        }
+
+        function search(query) {
+            const results = document.getElementById('searchResults');
+            const summary = document.getElementById('searchSummary');
+            results.innerHTML = '';
+            summary.textContent = '';
+            if (!query) {
+                return;
+            }
+
+            const haystack = plainText.toLowerCase();
+            const needle = query.toLowerCase();
+            const maxResults = 200;
+            let count = 0;
+            let index = haystack.indexOf(needle);
+            while (index !== -1 && count < maxResults) {
+                const end = index + needle.length;
+                const time = timeAtOffset(end - 1);
+                const before = plainText.substring(Math.max(0, index - 30), index).replace(/\n/g, ' ');
+                const match = plainText.substring(index, end);
+                const after = plainText.substring(end, end + 30).replace(/\n/g, ' ');
+
+                const item = document.createElement('div');
+                item.className = 'search-result';
+                item.innerHTML = '<span class="time">' + formatTime(time) + '</span>' +
+                    escapeHtml(before) + '<mark>' + escapeHtml(match) + '</mark>' + escapeHtml(after);
+                item.onclick = function() {
+                    setPlaying(false);
+                    seekTo(time);
+                };
+                results.appendChild(item);
+
+                count++;
+                index = haystack.indexOf(needle, end);
+            }
+            summary.textContent = count === 0 ? 'No matches' :
+                (index !== -1 ? 'Showing first ' + maxResults + ' matches' : count + ' match' + (count === 1 ? '' : 'es'));
+        }
+
+        window.addEventListener('load', async function() {
+            try {
+                await loadRecording();
+            } catch (error) {
+                document.getElementById('meta').textContent = 'Failed to load recording: ' + error.message;
+                return;
+            }
+
+            const started = header.timestamp ? new Date(header.timestamp * 1000).toLocaleString() : 'unknown';
+            document.getElementById('meta').textContent = (header.title || 'Recording') +
+                ' - started ' + started + ' - ' + formatTime(duration) + ' - ' + events.length + ' events';
+
+            initTerminal();
+
+            const seek = document.getElementById('seek');
+            seek.max = duration;
+            seek.disabled = false;
+            seek.addEventListener('input', function() {
+                seekTo(parseFloat(this.value));
+            });
+            document.getElementById('playBtn').disabled = false;
+
+            let searchTimer;
+            document.getElementById('searchInput').addEventListener('input', function() {
+                clearTimeout(searchTimer);
+                const query = this.value;
+                searchTimer = setTimeout(() => search(query), 200);
+            });
+
+            updateProgress();
+            setPlaying(true);
+        });
+    </script>
+</body>
+</html>`
+
+var (
+	playbackTemplate *template.Template
+)
+
+// GetPlaybackTemplate returns the parsed recording playback page template
+func GetPlaybackTemplate() (*template.Template, error) {
+	if playbackTemplate == nil {
+		var err error
+		playbackTemplate, err = template.New("playback").Parse(PlaybackTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return playbackTemplate, nil
+}
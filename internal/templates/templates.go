@@ -140,13 +140,14 @@ Response (JSON with Accept: application/json):
         <div class="allowed-commands">
             {{range .AllowedCommands}}
             <div class="command">{{.}}</div>
+            {{else}}
+            <div>{{if $.PolicyEnabled}}Commands are restricted per token by the command policy{{else}}No command policy configured, all commands are allowed{{end}}</div>
             {{end}}
         </div>
         
         <h2>Security Notes</h2>
         <ul>
-            <li>Only predefined commands are allowed for security</li>
-            <li>Commands are executed with a 30-second timeout</li>
+            <li>Commands are checked against the command policy when one is configured</li>
             <li>All command output is sanitized</li>
             <li>Use with caution in production environments</li>
         </ul>
@@ -143,6 +143,46 @@ const TerminalTemplate = `<!DOCTYPE html>
             background-color: #ffc107;
             color: black;
         }
+        .status-badge.mode {
+            background-color: #6c757d;
+            color: white;
+            display: none;
+        }
+        .notice-banner {
+            position: fixed;
+            top: 60px;
+            left: 0;
+            right: 0;
+            background-color: #ffc107;
+            color: #000000;
+            padding: 8px 20px;
+            font-size: 13px;
+            font-weight: bold;
+            z-index: 1002;
+            display: none;
+        }
+        .exit-panel {
+            position: fixed;
+            top: 60px;
+            left: 0;
+            right: 0;
+            background-color: #333333;
+            color: #ffffff;
+            padding: 8px 20px;
+            font-size: 13px;
+            z-index: 1002;
+            display: none;
+        }
+        .exit-panel .btn {
+            background-color: #28a745;
+            margin-left: 15px;
+        }
+        .presence {
+            color: #666666;
+            font-size: 12px;
+            margin-left: 10px;
+            font-family: 'Open Sans', sans-serif;
+        }
     </style>
 </head>
 <body>
@@ -150,6 +190,8 @@ const TerminalTemplate = `<!DOCTYPE html>
         <h1>SSH - Web Terminal</h1>
         <span class="hostname">@{{.Hostname}}</span>
         <span class="status-badge disconnected" id="statusBadge">Disconnected</span>
+        <span class="status-badge mode" id="modeBadge"></span>
+        <span class="presence" id="presence"></span>
         <p>WebShell</p>
         <div style="margin-top: 10px; display: flex; align-items: center; gap: 5px;">
             <input type="password" id="tokenInput" placeholder="Auth Token (optional)" style="padding: 5px 10px; border: 1px solid #ccc; border-radius: 3px; font-size: 12px; width: 200px;">
@@ -160,6 +202,11 @@ const TerminalTemplate = `<!DOCTYPE html>
     
     <div class="status" id="status">Ready to connect</div>
     
+    <div class="notice-banner" id="noticeBanner"></div>
+    <div class="exit-panel" id="exitPanel">
+        <span id="exitMessage"></span>
+        <button class="btn" id="restartBtn" onclick="restartShell()">Restart shell</button>
+    </div>
     <div class="terminal-container" id="terminal"></div>
     
     <div class="controls">
@@ -167,6 +214,7 @@ const TerminalTemplate = `<!DOCTYPE html>
         <button class="btn" id="disconnectBtn" onclick="disconnect()" disabled>Disconnect</button>
         <button class="btn" id="clearBtn" onclick="clearTerminal()">Clear</button>
         <button class="btn" id="fullscreenBtn" onclick="toggleFullscreen()">Fullscreen</button>
+        <button class="btn" id="shareBtn" onclick="shareSession()" disabled>Share</button>
     </div>
     
     <div class="back-link">
@@ -190,10 +238,39 @@ const TerminalTemplate = `<!DOCTYPE html>
             return basePath.endsWith('/') ? basePath : basePath + '/';
         }
         
+        // Framed wire protocol: binary messages starting with a one-byte opcode
+        const PROTOCOL = 'webshell.v1';
+        const OP_INPUT = 0x01;
+        const OP_OUTPUT = 0x02;
+        const OP_RESIZE = 0x03;
+        const OP_PING = 0x04;
+        const OP_PONG = 0x05;
+        const OP_EXIT = 0x06;
+        const OP_TITLE = 0x07;
+        const OP_SESSION = 0x08;
+        const OP_NOTICE = 0x09;
+        const OP_ACK = 0x0A;
+        const OP_PRESENCE = 0x0B;
+
+        // Output is acknowledged once xterm.js has rendered it, in steps of
+        // ACK_STEP bytes, so the server pauses the shell instead of flooding
+        // the browser
+        const ACK_STEP = 64 * 1024;
+        let unacked = 0;
+        const textEncoder = new TextEncoder();
+        const textDecoder = new TextDecoder();
+        const defaultTitle = document.title;
+
         let term;
         let socket;
         let fitAddon;
         let isConnected = false;
+        let sessionId = sessionStorage.getItem('webshell_session_id');
+        // Set once the server reports that the shell has ended
+        let shellEnded = false;
+        // Share key when joining someone else's session through a share link
+        const shareKey = new URLSearchParams(window.location.search).get('share');
+        let viewerMode = 'owner';
 
         // Initialize terminal
         function initTerminal() {
@@ -232,25 +309,21 @@ const TerminalTemplate = `<!DOCTYPE html>
 
             // Handle terminal input
             term.onData(data => {
-                if (socket && socket.readyState === WebSocket.OPEN) {
-                    socket.send(data);
+                sendFrame(OP_INPUT, textEncoder.encode(data));
+            });
+            term.onBinary(data => {
+                const bytes = new Uint8Array(data.length);
+                for (let i = 0; i < data.length; i++) {
+                    bytes[i] = data.charCodeAt(i) & 0xff;
                 }
+                sendFrame(OP_INPUT, bytes);
             });
 
             // Handle window resize
             window.addEventListener('resize', () => {
                 if (fitAddon) {
                     fitAddon.fit();
-                    if (socket && socket.readyState === WebSocket.OPEN) {
-                        const dims = fitAddon.proposeDimensions();
-                        if (dims) {
-                            socket.send(JSON.stringify({
-                                type: 'resize',
-                                cols: dims.cols,
-                                rows: dims.rows
-                            }));
-                        }
-                    }
+                    sendResize();
                 }
             });
 
@@ -331,47 +404,89 @@ const TerminalTemplate = `<!DOCTYPE html>
             const basePath = getBasePath();
             // Get token from multiple sources
             const token = getToken();
-            let wsUrl = protocol + '//' + window.location.host + basePath + 'ws';
+            const params = new URLSearchParams();
             if (token) {
-                wsUrl += '?token=' + encodeURIComponent(token);
+                params.set('token', token);
             }
-            
-            socket = new WebSocket(wsUrl);
+            if (shareKey) {
+                // Join a shared session
+                params.set('share', shareKey);
+            } else if (sessionId) {
+                // Resume the previous shell if the server still has it
+                params.set('session', sessionId);
+            }
+            // Pass shell options from the page URL (shell, arg, login, cwd, env)
+            const pageParams = new URLSearchParams(window.location.search);
+            for (const name of ['shell', 'arg', 'login', 'cwd', 'env']) {
+                for (const value of pageParams.getAll(name)) {
+                    params.append(name, value);
+                }
+            }
+            // Size a new shell before it starts so the first prompt renders correctly
+            const dims = fitAddon.proposeDimensions();
+            if (dims) {
+                params.set('cols', dims.cols);
+                params.set('rows', dims.rows);
+            }
+            let wsUrl = protocol + '//' + window.location.host + basePath + 'ws';
+            if (params.toString()) {
+                wsUrl += '?' + params.toString();
+            }
+            socket = new WebSocket(wsUrl, [PROTOCOL]);
+            socket.binaryType = 'arraybuffer';
+            let opened = false;
 
             socket.onopen = function(event) {
+                opened = true;
                 isConnected = true;
+                shellEnded = false;
+                document.getElementById('exitPanel').style.display = 'none';
                 updateStatus('Connected', 'connected');
                 updateButtons(false, true);
                 term.write('\r\nConnected to WebShell\r\n');
                 
-                // Send initial resize
-                const dims = fitAddon.proposeDimensions();
-                if (dims) {
-                    socket.send(JSON.stringify({
-                        type: 'resize',
-                        cols: dims.cols,
-                        rows: dims.rows
-                    }));
-                }
+                // Send initial resize and opt in to flow control
+                sendResize();
+                unacked = 0;
+                sendAck(0);
+                startHeartbeat();
             };
 
             socket.onmessage = function(event) {
-                term.write(event.data);
+                lastSeen = Date.now();
+                if (!(event.data instanceof ArrayBuffer) || event.data.byteLength === 0) {
+                    return;
+                }
+                const frame = new Uint8Array(event.data);
+                const payload = frame.subarray(1);
+                switch (frame[0]) {
+                    case OP_OUTPUT: {
+                        const length = payload.length;
+                        term.write(payload, () => acknowledge(length));
+                        break;
+                    }
+                    case OP_SESSION:
+                        handleSession(JSON.parse(textDecoder.decode(payload)));
+                        break;
+                    case OP_TITLE:
+                        document.title = textDecoder.decode(payload) || defaultTitle;
+                        break;
+                    case OP_EXIT:
+                        handleExit(JSON.parse(textDecoder.decode(payload)));
+                        break;
+                    case OP_NOTICE:
+                        handleNotice(JSON.parse(textDecoder.decode(payload)));
+                        break;
+                    case OP_PRESENCE:
+                        handlePresence(JSON.parse(textDecoder.decode(payload)));
+                        break;
+                    case OP_PONG:
+                        break;
+                }
             };
 
             socket.onclose = function(event) {
-                isConnected = false;
-                updateStatus('Disconnected', 'disconnected');
-                updateButtons(false, false);
-                term.write('\r\nDisconnected from WebShell\r\n');
-                
-                // Auto-reconnect after 2 seconds
-                setTimeout(() => {
-                    if (!isConnected) {
-                        term.write('\r\nReconnecting...\r\n');
-                        connect();
-                    }
-                }, 2000);
+                handleClose(event.code, event.reason, !opened);
             };
 
             socket.onerror = function(error) {
@@ -383,6 +498,243 @@ const TerminalTemplate = `<!DOCTYPE html>
             };
         }
 
+        // Handle a closed or dead connection, reconnecting unless access was refused.
+        // refused is set when the handshake failed, e.g. with 429 at the session limit.
+        function handleClose(code, reason, refused) {
+            isConnected = false;
+            stopHeartbeat();
+            hideNotice();
+            updateStatus('Disconnected', 'disconnected');
+            updateButtons(false, false);
+            clearPresence();
+            term.write('\r\nDisconnected from WebShell' + (reason ? ': ' + reason : '') + '\r\n');
+
+            // The shell is gone, let the user decide when to start a new one
+            if (shellEnded) {
+                showExit(exitMessage || 'Session ended' + (reason ? ': ' + reason : ''));
+                return;
+            }
+
+            // Access was refused (invalid share link, taken over elsewhere), do not retry
+            if (code === 1008) {
+                return;
+            }
+
+            // Auto-reconnect after 2 seconds, or 10 when the server is busy
+            // (session limit reached) or refused the connection
+            setTimeout(() => {
+                if (!isConnected) {
+                    term.write('\r\nReconnecting...\r\n');
+                    connect();
+                }
+            }, code === 1013 || refused ? 10000 : 2000);
+        }
+
+        // Describe how the shell ended and offer to start a new one
+        let exitMessage = '';
+        function handleExit(status) {
+            shellEnded = true;
+            hideNotice();
+            exitMessage = status.signal
+                ? 'Shell was killed by ' + status.signal
+                : 'Shell exited with code ' + status.exit_code;
+            if (status.duration) {
+                exitMessage += ' after ' + status.duration;
+            }
+            if (status.reason) {
+                exitMessage += ' (' + status.reason + ')';
+            }
+            term.write('\r\n\x1b[1m' + exitMessage + '\x1b[0m\r\n');
+        }
+
+        function showExit(message) {
+            document.getElementById('exitMessage').textContent = message;
+            // Viewers of a shared session cannot start its shell again
+            document.getElementById('restartBtn').style.display = shareKey ? 'none' : '';
+            document.getElementById('exitPanel').style.display = 'block';
+        }
+
+        function restartShell() {
+            document.getElementById('exitPanel').style.display = 'none';
+            shellEnded = false;
+            exitMessage = '';
+            sessionId = null;
+            sessionStorage.removeItem('webshell_session_id');
+            term.reset();
+            connect();
+        }
+
+        // Show or clear a warning about an upcoming idle or maximum duration termination
+        let noticeTimer = null;
+        function handleNotice(notice) {
+            if (notice.type !== 'warning') {
+                hideNotice();
+                return;
+            }
+            const banner = document.getElementById('noticeBanner');
+            const expiresAt = new Date(notice.expires_at).getTime();
+            const render = () => {
+                const seconds = Math.max(0, Math.round((expiresAt - Date.now()) / 1000));
+                banner.textContent = notice.reason === 'idle'
+                    ? 'This session is idle and will be terminated in ' + seconds + 's. Press a key to keep it open.'
+                    : 'This session has reached its maximum duration and will be terminated in ' + seconds + 's.';
+            };
+            render();
+            banner.style.display = 'block';
+            clearInterval(noticeTimer);
+            noticeTimer = setInterval(render, 1000);
+        }
+
+        function hideNotice() {
+            clearInterval(noticeTimer);
+            noticeTimer = null;
+            document.getElementById('noticeBanner').style.display = 'none';
+        }
+
+        // Count rendered output and acknowledge it in ACK_STEP steps
+        function acknowledge(length) {
+            unacked += length;
+            if (unacked >= ACK_STEP) {
+                sendAck(unacked);
+                unacked = 0;
+            }
+        }
+
+        function sendAck(count) {
+            const payload = new Uint8Array(4);
+            new DataView(payload.buffer).setUint32(0, count);
+            sendFrame(OP_ACK, payload);
+        }
+
+        // Heartbeat: ping the server regularly and treat a connection that has
+        // been silent for too long (e.g. half-open behind a load balancer) as dead
+        const HEARTBEAT_INTERVAL = 15000;
+        const HEARTBEAT_TIMEOUT = 45000;
+        let heartbeatTimer = null;
+        let lastSeen = 0;
+
+        function startHeartbeat() {
+            lastSeen = Date.now();
+            clearInterval(heartbeatTimer);
+            heartbeatTimer = setInterval(() => {
+                if (Date.now() - lastSeen > HEARTBEAT_TIMEOUT) {
+                    connectionLost();
+                    return;
+                }
+                sendFrame(OP_PING, textEncoder.encode(String(Date.now())));
+            }, HEARTBEAT_INTERVAL);
+        }
+
+        function stopHeartbeat() {
+            clearInterval(heartbeatTimer);
+            heartbeatTimer = null;
+        }
+
+        // Abandon a dead socket without waiting for its close handshake
+        function connectionLost() {
+            const dead = socket;
+            dead.onclose = null;
+            dead.onerror = null;
+            dead.onmessage = null;
+            dead.close();
+            handleClose(4000, 'no response from server');
+        }
+
+        // Send a frame with the given opcode and payload
+        function sendFrame(op, payload) {
+            if (!socket || socket.readyState !== WebSocket.OPEN) {
+                return;
+            }
+            const frame = new Uint8Array(1 + payload.length);
+            frame[0] = op;
+            frame.set(payload, 1);
+            socket.send(frame);
+        }
+
+        // Tell the server the terminal size (ignored for shared viewers)
+        function sendResize() {
+            const dims = fitAddon.proposeDimensions();
+            if (!dims || shareKey) {
+                return;
+            }
+            const payload = new Uint8Array(4);
+            const view = new DataView(payload.buffer);
+            view.setUint16(0, dims.cols);
+            view.setUint16(2, dims.rows);
+            sendFrame(OP_RESIZE, payload);
+        }
+
+        // The session frame identifies the session we are attached to
+        function handleSession(msg) {
+            if (msg.resumed) {
+                // Scrollback is replayed next, start from a clean screen
+                term.reset();
+            } else if (sessionId && !shareKey) {
+                term.write('\r\nPrevious session has ended, started a new shell\r\n');
+            }
+            sessionId = msg.id;
+            setViewerMode(msg.mode || 'owner');
+            if (!shareKey) {
+                sessionStorage.setItem('webshell_session_id', sessionId);
+            }
+        }
+
+        // Apply the access mode granted by the server
+        function setViewerMode(mode) {
+            viewerMode = mode;
+            term.options.disableStdin = mode === 'read-only';
+            const badge = document.getElementById('modeBadge');
+            badge.textContent = mode === 'owner' ? '' : (mode === 'read-only' ? 'Read-only' : 'Shared');
+            badge.style.display = mode === 'owner' ? 'none' : 'inline';
+            document.getElementById('shareBtn').disabled = mode !== 'owner';
+        }
+
+        // Build request headers carrying the auth token
+        function authHeaders() {
+            const headers = {};
+            const token = getToken();
+            if (token) {
+                headers['X-Auth-Token'] = token;
+            }
+            return headers;
+        }
+
+        // Create a share link for the current session
+        async function shareSession() {
+            if (!sessionId) return;
+            const readWrite = confirm('Allow the people you share with to type in this terminal?\n\nOK = read-write, Cancel = read-only');
+            const headers = authHeaders();
+            headers['Content-Type'] = 'application/json';
+            try {
+                const response = await fetch(getBasePath() + 'sessions/' + sessionId + '/share', {
+                    method: 'POST',
+                    headers: headers,
+                    body: JSON.stringify({ mode: readWrite ? 'read-write' : 'read-only' })
+                });
+                if (!response.ok) {
+                    alert('Failed to share session: ' + await response.text());
+                    return;
+                }
+                const data = await response.json();
+                const link = window.location.origin + getBasePath() + data.path;
+                prompt('Share link (' + data.mode + '):', link);
+            } catch (error) {
+                alert('Failed to share session: ' + error.message);
+            }
+        }
+
+        // Show who else is attached, sent by the server whenever someone joins or leaves
+        function handlePresence(msg) {
+            const viewers = msg.viewers || [];
+            const names = viewers.map(v => v.principal + ' (' + v.mode + ', ' + v.remote_addr + ')');
+            document.getElementById('presence').textContent =
+                viewers.length > 1 ? viewers.length + ' viewers: ' + names.join(', ') : '';
+        }
+
+        function clearPresence() {
+            document.getElementById('presence').textContent = '';
+        }
+
         // Disconnect from WebSocket
         function disconnect() {
             if (socket) {
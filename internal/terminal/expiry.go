@@ -0,0 +1,134 @@
+package terminal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/adaptive-scale/webshell/internal/config"
+)
+
+// expiryCheckInterval is how often the idle timeout and maximum duration are
+// checked
+const expiryCheckInterval = time.Second
+
+// Reasons a session is terminated by the server
+const (
+	reasonIdle        = "idle"
+	reasonMaxDuration = "max_duration"
+)
+
+// noticeMessage warns clients about an upcoming termination, or tells them a
+// warning no longer applies
+type noticeMessage struct {
+	Type      string     `json:"type"` // "warning" or "cleared"
+	Reason    string     `json:"reason"`
+	Message   string     `json:"message,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Remaining int        `json:"remaining,omitempty"` // seconds
+}
+
+// touch records input or output on the session
+func (ts *TerminalSession) touch() {
+	ts.lastActivity.Store(time.Now().UnixNano())
+}
+
+// watch enforces the idle timeout and maximum duration for the lifetime of
+// the session, warning attached clients before terminating it
+func (ts *TerminalSession) watch() {
+	idle, maxDuration := config.GetIdleTimeout(), config.GetMaxSessionDuration()
+	if idle <= 0 && maxDuration <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(expiryCheckInterval)
+	defer ticker.Stop()
+
+	warned := make(map[string]bool)
+	for {
+		select {
+		case <-ts.done:
+			return
+		case now := <-ticker.C:
+			checks := []struct {
+				reason   string
+				limit    time.Duration
+				deadline time.Time
+			}{
+				{reasonMaxDuration, maxDuration, ts.startedAt.Add(maxDuration)},
+				{reasonIdle, idle, time.Unix(0, ts.lastActivity.Load()).Add(idle)},
+			}
+			for _, check := range checks {
+				if check.limit <= 0 {
+					continue
+				}
+				remaining := check.deadline.Sub(now)
+				if remaining <= 0 {
+					ts.expire(check.reason, check.limit)
+					return
+				}
+
+				// Never warn for more than half the limit, so activity
+				// always clears an idle warning
+				lead := config.GetSessionWarning()
+				if lead > check.limit/2 {
+					lead = check.limit / 2
+				}
+				switch {
+				case remaining <= lead && !warned[check.reason]:
+					warned[check.reason] = true
+					expiresAt := check.deadline.UTC()
+					ts.notify(noticeMessage{
+						Type:      "warning",
+						Reason:    check.reason,
+						Message:   expiryMessage(check.reason, check.limit, remaining),
+						ExpiresAt: &expiresAt,
+						Remaining: int(remaining.Round(time.Second) / time.Second),
+					})
+				case remaining > lead && warned[check.reason]:
+					warned[check.reason] = false
+					ts.notify(noticeMessage{Type: "cleared", Reason: check.reason})
+				}
+			}
+		}
+	}
+}
+
+// expire terminates the session, recording why in the close frames sent to
+// its clients
+func (ts *TerminalSession) expire(reason string, limit time.Duration) {
+	message := closeReason(reason, limit)
+	log.Printf("Terminal session %s: %s, terminating", ts.id, message)
+	ts.terminate(websocket.ClosePolicyViolation, message)
+}
+
+// notify sends a notice to every attached client
+func (ts *TerminalSession) notify(notice noticeMessage) {
+	data, err := json.Marshal(notice)
+	if err != nil {
+		return
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.broadcast(message{op: OpNotice, data: data})
+}
+
+// expiryMessage describes an upcoming termination for the warning banner
+func expiryMessage(reason string, limit, remaining time.Duration) string {
+	in := remaining.Round(time.Second)
+	if reason == reasonIdle {
+		return fmt.Sprintf("Session will be terminated in %s after %s without activity", in, limit)
+	}
+	return fmt.Sprintf("Session will be terminated in %s, it has reached the maximum duration of %s", in, limit)
+}
+
+// closeReason is the close frame reason for a terminated session
+func closeReason(reason string, limit time.Duration) string {
+	if reason == reasonIdle {
+		return fmt.Sprintf("idle timeout: no activity for %s", limit)
+	}
+	return fmt.Sprintf("maximum session duration of %s reached", limit)
+}
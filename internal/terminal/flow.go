@@ -0,0 +1,89 @@
+package terminal
+
+import (
+	"time"
+)
+
+// Output pipeline tuning. The PTY is read in large chunks by one goroutine and
+// handed to the session through a short queue; reads that arrive close
+// together are coalesced into one frame so bulk output such as `cat bigfile`
+// does not turn into thousands of tiny WebSocket messages.
+const (
+	// readBufferSize is the size of a single PTY read
+	readBufferSize = 32 * 1024
+	// outputQueueSize is how many reads may wait to be framed before the
+	// reader stops reading the PTY
+	outputQueueSize = 4
+	// maxBatchSize is the largest output frame sent to clients
+	maxBatchSize = 64 * 1024
+	// batchDelay is how long a frame is held open for more output while the
+	// shell is producing output faster than it can be read
+	batchDelay = 5 * time.Millisecond
+	// bulkReadSize is the read size from which output is considered bulk
+	// rather than interactive. The kernel hands out PTY output in pieces of
+	// at most a few KB, so reads rarely fill the buffer.
+	bulkReadSize = 1024
+)
+
+// Flow control. Clients that acknowledge output with OpAck are never more
+// than ackWindow bytes behind: the session stops reading the PTY until they
+// catch up, which in turn blocks the writing process. Clients that do not
+// acknowledge are disconnected once their queue holds viewerQueueBytes.
+const (
+	ackWindow        = 1024 * 1024
+	viewerQueueBytes = 4 * 1024 * 1024
+)
+
+// coalesce reads chunks until the channel is closed and passes them to flush
+// in batches of at most maxBatchSize bytes. A batch is flushed as soon as no
+// more output is queued, unless the last read was bulk sized, in which case
+// more output is likely and the batch waits up to batchDelay for it.
+// Interactive output is therefore sent immediately.
+func coalesce(chunks <-chan []byte, flush func([]byte)) {
+	timer := time.NewTimer(batchDelay)
+	timer.Stop()
+
+	for chunk := range chunks {
+		batch := chunk
+		bulk := len(chunk) >= bulkReadSize
+		open := true
+
+	collect:
+		for open && len(batch) < maxBatchSize {
+			// Take whatever is already queued
+			select {
+			case chunk, open = <-chunks:
+				batch = append(batch, chunk...)
+				bulk = len(chunk) >= bulkReadSize
+				continue
+			default:
+			}
+			if !bulk {
+				break
+			}
+
+			// The shell is still writing, give it a moment
+			timer.Reset(batchDelay)
+			select {
+			case chunk, open = <-chunks:
+				if !timer.Stop() {
+					<-timer.C
+				}
+				batch = append(batch, chunk...)
+				bulk = len(chunk) >= bulkReadSize
+			case <-timer.C:
+				break collect
+			}
+		}
+
+		for len(batch) > maxBatchSize {
+			flush(batch[:maxBatchSize])
+			batch = batch[maxBatchSize:]
+		}
+		flush(batch)
+
+		if !open {
+			return
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package terminal
+
+import (
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+)
+
+func collect(chunks ...[]byte) [][]byte {
+	queue := make(chan []byte, len(chunks))
+	for _, chunk := range chunks {
+		queue <- chunk
+	}
+	close(queue)
+
+	var frames [][]byte
+	coalesce(queue, func(frame []byte) {
+		frames = append(frames, frame)
+	})
+	return frames
+}
+
+func TestCoalesceBatchesQueuedOutput(t *testing.T) {
+	full := bytes.Repeat([]byte("x"), readBufferSize)
+	frames := collect(full, full, full, []byte("tail"))
+
+	// 3 large reads and a short one make 2 frames of at most maxBatchSize
+	if len(frames) != 2 {
+		t.Fatalf("got %d frames, want 2", len(frames))
+	}
+	if len(frames[0]) != maxBatchSize || len(frames[1]) != readBufferSize+4 {
+		t.Fatalf("got frame sizes %d and %d", len(frames[0]), len(frames[1]))
+	}
+}
+
+func TestCoalesceFlushesInteractiveOutput(t *testing.T) {
+	queue := make(chan []byte)
+	flushed := make(chan []byte)
+	go coalesce(queue, func(frame []byte) {
+		flushed <- frame
+	})
+	defer close(queue)
+
+	// A short read means the shell is waiting, so the echo is sent at once
+	queue <- []byte("a")
+	if frame := <-flushed; string(frame) != "a" {
+		t.Fatalf("got frame %q", frame)
+	}
+}
+
+// BenchmarkSessionOutput measures throughput from a shell writing to the PTY
+// through the pump, and reports how many frames the output was sent in
+func BenchmarkSessionOutput(b *testing.B) {
+	const size = 16 * 1024 * 1024
+	opts := shellOptions{path: "/bin/sh", args: []string{"-c", fmt.Sprintf("head -c %d /dev/zero", size)}}
+
+	b.SetBytes(size)
+	var frames int64
+	for i := 0; i < b.N; i++ {
+		ts, err := newSession(httptest.NewRequest("GET", "/ws", nil), defaultCols, defaultRows, opts, func() {})
+		if err != nil {
+			b.Fatal(err)
+		}
+		<-ts.done
+		frames += ts.framesOut.Load()
+	}
+	b.ReportMetric(float64(frames)/float64(b.N), "frames/op")
+}
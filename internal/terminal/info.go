@@ -0,0 +1,141 @@
+package terminal
+
+import (
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// SessionInfo describes a live terminal session for the sessions API
+type SessionInfo struct {
+	ID         string       `json:"id"`
+	Owner      string       `json:"owner"`
+	RunAs      string       `json:"run_as,omitempty"`
+	RemoteAddr string       `json:"remote_addr"`
+	UserAgent  string       `json:"user_agent,omitempty"`
+	PID        int          `json:"pid"`
+	Cols       int          `json:"cols"`
+	Rows       int          `json:"rows"`
+	StartedAt  time.Time    `json:"started_at"`
+	Duration   string       `json:"duration"`
+	Attached   bool         `json:"attached"`
+	DetachedAt *time.Time   `json:"detached_at,omitempty"`
+	Viewers    []ViewerInfo `json:"viewers"`
+	Shares     int          `json:"shares"`
+	BytesIn    int64        `json:"bytes_in"`
+	BytesOut   int64        `json:"bytes_out"`
+	FramesOut  int64        `json:"frames_out"`
+	WebSocket  TrafficInfo  `json:"websocket"`
+	IdleFor    string       `json:"idle_for"`
+}
+
+// TrafficInfo counts the WebSocket bytes of all connections a session has
+// had, before compression and on the wire (compressed, including frame
+// headers and control frames)
+type TrafficInfo struct {
+	UncompressedBytesOut int64   `json:"uncompressed_bytes_out"`
+	CompressedBytesOut   int64   `json:"compressed_bytes_out"`
+	UncompressedBytesIn  int64   `json:"uncompressed_bytes_in"`
+	CompressedBytesIn    int64   `json:"compressed_bytes_in"`
+	CompressionRatio     float64 `json:"compression_ratio"`
+}
+
+// info returns a snapshot of the session state
+func (ts *TerminalSession) info() SessionInfo {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	info := SessionInfo{
+		ID:         ts.id,
+		Owner:      ts.owner,
+		RunAs:      ts.runAs,
+		RemoteAddr: ts.remoteAddr,
+		UserAgent:  ts.userAgent,
+		PID:        ts.cmd.Process.Pid,
+		Cols:       ts.cols,
+		Rows:       ts.rows,
+		StartedAt:  ts.startedAt.UTC(),
+		Duration:   time.Since(ts.startedAt).Round(time.Second).String(),
+		Attached:   len(ts.viewers) > 0,
+		Shares:     len(ts.shares),
+		BytesIn:    ts.bytesIn.Load(),
+		BytesOut:   ts.bytesOut.Load(),
+		FramesOut:  ts.framesOut.Load(),
+		IdleFor:    time.Since(time.Unix(0, ts.lastActivity.Load())).Round(time.Second).String(),
+	}
+	total := ts.pastTraffic
+	for v := range ts.viewers {
+		total.add(v.traffic())
+	}
+	info.Viewers = ts.viewerInfosLocked()
+	info.WebSocket = TrafficInfo{
+		UncompressedBytesOut: total.uncompressedOut,
+		CompressedBytesOut:   total.compressedOut,
+		UncompressedBytesIn:  total.uncompressedIn,
+		CompressedBytesIn:    total.compressedIn,
+	}
+	if total.compressedOut > 0 {
+		info.WebSocket.CompressionRatio = math.Round(float64(total.uncompressedOut)/float64(total.compressedOut)*100) / 100
+	}
+	if len(ts.viewers) == 0 && !ts.detachedAt.IsZero() {
+		detachedAt := ts.detachedAt.UTC()
+		info.DetachedAt = &detachedAt
+	}
+	return info
+}
+
+// viewerInfosLocked describes the attached clients, oldest first. Must be
+// called with the lock held.
+func (ts *TerminalSession) viewerInfosLocked() []ViewerInfo {
+	viewers := make([]ViewerInfo, 0, len(ts.viewers))
+	for v := range ts.viewers {
+		viewers = append(viewers, v.info())
+	}
+	sort.Slice(viewers, func(i, j int) bool {
+		return viewers[i].JoinedAt.Before(viewers[j].JoinedAt)
+	})
+	return viewers
+}
+
+// ListSessions returns all live terminal sessions, oldest first
+func ListSessions() []SessionInfo {
+	registry.mu.RLock()
+	sessions := make([]*TerminalSession, 0, len(registry.sessions))
+	for _, ts := range registry.sessions {
+		sessions = append(sessions, ts)
+	}
+	registry.mu.RUnlock()
+
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, ts := range sessions {
+		infos = append(infos, ts.info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].StartedAt.Before(infos[j].StartedAt)
+	})
+	return infos
+}
+
+// GetSession returns information about a single session
+func GetSession(id string) (SessionInfo, bool) {
+	ts, ok := registry.get(id)
+	if !ok {
+		return SessionInfo{}, false
+	}
+	return ts.info(), true
+}
+
+// KillSession terminates a session's shell, telling its clients why. It
+// reports false if the session does not exist.
+func KillSession(id, reason string) bool {
+	ts, ok := registry.get(id)
+	if !ok {
+		return false
+	}
+	log.Printf("Terminal session %s terminated via API: %s", id, reason)
+	ts.terminate(websocket.CloseGoingAway, reason)
+	return true
+}
@@ -0,0 +1,158 @@
+package terminal
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"regexp"
+
+	"github.com/gorilla/websocket"
+)
+
+// Subprotocol is the Sec-WebSocket-Protocol value of the framed wire protocol.
+// Clients that do not offer it get the legacy text protocol, if enabled.
+const Subprotocol = "webshell.v1"
+
+// Opcodes of the framed protocol. Every frame is a binary WebSocket message
+// whose first byte is the opcode, followed by the payload.
+const (
+	// OpInput carries raw keyboard input (client to server)
+	OpInput byte = 0x01
+	// OpOutput carries raw PTY output (server to client)
+	OpOutput byte = 0x02
+	// OpResize carries the terminal size as two big-endian uint16, cols then rows (client to server)
+	OpResize byte = 0x03
+	// OpPing asks the server to echo the payload in an OpPong (client to server)
+	OpPing byte = 0x04
+	// OpPong answers an OpPing with the same payload (server to client)
+	OpPong byte = 0x05
+	// OpExit carries a JSON exit status once the shell has exited (server to client)
+	OpExit byte = 0x06
+	// OpTitle carries a UTF-8 window title set by the shell (server to client)
+	OpTitle byte = 0x07
+	// OpSession carries a JSON session message, always the first frame (server to client)
+	OpSession byte = 0x08
+	// OpNotice carries a JSON notice such as an upcoming idle or maximum
+	// duration termination (server to client)
+	OpNotice byte = 0x09
+	// OpAck acknowledges output the client has processed as a big-endian
+	// uint32 byte count, enabling flow control for the connection (client to server)
+	OpAck byte = 0x0A
+	// OpPresence carries a JSON list of the clients attached to the session,
+	// sent whenever one joins or leaves (server to client)
+	OpPresence byte = 0x0B
+)
+
+var errMalformedFrame = errors.New("malformed frame")
+
+// message is a protocol-independent message queued for a client
+type message struct {
+	op   byte
+	data []byte
+}
+
+// clientMessage is a decoded message received from a client
+type clientMessage struct {
+	op         byte
+	data       []byte
+	cols, rows int
+	acked      int
+}
+
+// protocol encodes server messages and decodes client messages for one
+// WebSocket dialect
+type protocol interface {
+	// encode returns the WebSocket message for msg, or false if the
+	// dialect cannot represent it
+	encode(msg message) (int, []byte, bool)
+	// decode parses a WebSocket message received from the client
+	decode(messageType int, data []byte) (clientMessage, error)
+}
+
+// framedProtocol implements the binary opcode protocol negotiated as Subprotocol
+type framedProtocol struct{}
+
+func (framedProtocol) encode(msg message) (int, []byte, bool) {
+	frame := make([]byte, 1+len(msg.data))
+	frame[0] = msg.op
+	copy(frame[1:], msg.data)
+	return websocket.BinaryMessage, frame, true
+}
+
+func (framedProtocol) decode(messageType int, data []byte) (clientMessage, error) {
+	if messageType != websocket.BinaryMessage || len(data) == 0 {
+		return clientMessage{}, errMalformedFrame
+	}
+	msg := clientMessage{op: data[0], data: data[1:]}
+	switch msg.op {
+	case OpInput, OpPing:
+	case OpResize:
+		if len(msg.data) != 4 {
+			return clientMessage{}, errMalformedFrame
+		}
+		msg.cols = int(binary.BigEndian.Uint16(msg.data[0:2]))
+		msg.rows = int(binary.BigEndian.Uint16(msg.data[2:4]))
+	case OpAck:
+		if len(msg.data) != 4 {
+			return clientMessage{}, errMalformedFrame
+		}
+		msg.acked = int(binary.BigEndian.Uint32(msg.data))
+	default:
+		return clientMessage{}, errMalformedFrame
+	}
+	return msg, nil
+}
+
+// legacyProtocol implements the original protocol: output and input as plain
+// text frames, with JSON resize messages recognised by a leading '{'
+type legacyProtocol struct{}
+
+func (legacyProtocol) encode(msg message) (int, []byte, bool) {
+	switch msg.op {
+	case OpOutput, OpSession:
+		return websocket.TextMessage, msg.data, true
+	case OpNotice:
+		// Old clients have no banner, show warnings in the terminal
+		var notice noticeMessage
+		if err := json.Unmarshal(msg.data, &notice); err != nil || notice.Type != "warning" {
+			return 0, nil, false
+		}
+		return websocket.TextMessage, []byte("\r\n\x1b[33m*** " + notice.Message + " ***\x1b[0m\r\n"), true
+	}
+	return 0, nil, false
+}
+
+func (legacyProtocol) decode(messageType int, data []byte) (clientMessage, error) {
+	if len(data) > 0 && data[0] == '{' {
+		var resizeMsg struct {
+			Type string `json:"type"`
+			Cols int    `json:"cols"`
+			Rows int    `json:"rows"`
+		}
+		if err := json.Unmarshal(data, &resizeMsg); err == nil && resizeMsg.Type == "resize" {
+			return clientMessage{op: OpResize, cols: resizeMsg.Cols, rows: resizeMsg.Rows}, nil
+		}
+	}
+	return clientMessage{op: OpInput, data: data}, nil
+}
+
+// protocolFor returns the dialect negotiated for conn
+func protocolFor(conn *websocket.Conn) protocol {
+	if conn.Subprotocol() == Subprotocol {
+		return framedProtocol{}
+	}
+	return legacyProtocol{}
+}
+
+// titleSequence matches the OSC 0 and OSC 2 escape sequences shells use to set
+// the window title
+var titleSequence = regexp.MustCompile(`\x1b\][02];([^\x07\x1b]*)(?:\x07|\x1b\\)`)
+
+// titleFromOutput returns the last window title set in p, if any
+func titleFromOutput(p []byte) (string, bool) {
+	matches := titleSequence.FindAllSubmatch(p, -1)
+	if len(matches) == 0 {
+		return "", false
+	}
+	return string(matches[len(matches)-1][1]), true
+}
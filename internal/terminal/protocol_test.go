@@ -0,0 +1,52 @@
+package terminal
+
+import (
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestFramedProtocolDecode(t *testing.T) {
+	tests := []struct {
+		name        string
+		messageType int
+		data        []byte
+		want        clientMessage
+		malformed   bool
+	}{
+		{"input", websocket.BinaryMessage, []byte{OpInput, 'l', 's'}, clientMessage{op: OpInput, data: []byte("ls")}, false},
+		{"empty input", websocket.BinaryMessage, []byte{OpInput}, clientMessage{op: OpInput, data: []byte{}}, false},
+		{"ping", websocket.BinaryMessage, []byte{OpPing, 1, 2}, clientMessage{op: OpPing, data: []byte{1, 2}}, false},
+		{"resize", websocket.BinaryMessage, []byte{OpResize, 0, 120, 0, 40}, clientMessage{op: OpResize, cols: 120, rows: 40}, false},
+		{"ack", websocket.BinaryMessage, []byte{OpAck, 0, 1, 0, 0}, clientMessage{op: OpAck, acked: 65536}, false},
+		{"text message", websocket.TextMessage, []byte{OpInput, 'l'}, clientMessage{}, true},
+		{"empty frame", websocket.BinaryMessage, []byte{}, clientMessage{}, true},
+		{"nil frame", websocket.BinaryMessage, nil, clientMessage{}, true},
+		{"truncated resize", websocket.BinaryMessage, []byte{OpResize, 0, 120, 0}, clientMessage{}, true},
+		{"resize without size", websocket.BinaryMessage, []byte{OpResize}, clientMessage{}, true},
+		{"oversized resize", websocket.BinaryMessage, []byte{OpResize, 0, 120, 0, 40, 0}, clientMessage{}, true},
+		{"truncated ack", websocket.BinaryMessage, []byte{OpAck, 0, 1}, clientMessage{}, true},
+		{"unknown opcode", websocket.BinaryMessage, []byte{0xFF, 1}, clientMessage{}, true},
+		{"server opcode", websocket.BinaryMessage, []byte{OpOutput, 'x'}, clientMessage{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := framedProtocol{}.decode(tt.messageType, tt.data)
+			if tt.malformed {
+				if err != errMalformedFrame {
+					t.Fatalf("decode = %+v, %v, want errMalformedFrame", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if got.op != tt.want.op || got.cols != tt.want.cols || got.rows != tt.want.rows || got.acked != tt.want.acked {
+				t.Fatalf("decode = %+v, want %+v", got, tt.want)
+			}
+			if (tt.want.op == OpInput || tt.want.op == OpPing) && string(got.data) != string(tt.want.data) {
+				t.Fatalf("decode data = %q, want %q", got.data, tt.want.data)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package terminal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+)
+
+// sessionRegistry keeps track of live terminal sessions by ID
+type sessionRegistry struct {
+	mu       sync.RWMutex
+	sessions map[string]*TerminalSession
+}
+
+var registry = &sessionRegistry{
+	sessions: make(map[string]*TerminalSession),
+}
+
+// add registers a session under its ID
+func (r *sessionRegistry) add(ts *TerminalSession) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions[ts.id] = ts
+}
+
+// get looks up a session by ID
+func (r *sessionRegistry) get(id string) (*TerminalSession, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ts, ok := r.sessions[id]
+	return ts, ok
+}
+
+// remove drops a session from the registry
+func (r *sessionRegistry) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions, id)
+}
+
+// newSessionID generates a random session identifier
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,28 @@
+package terminal
+
+// scrollbackSize is the amount of recent PTY output kept for replay on reattach
+const scrollbackSize = 256 * 1024
+
+// scrollback is a fixed-size buffer holding the most recent PTY output
+type scrollback struct {
+	buf []byte
+}
+
+// Write appends data, discarding the oldest bytes once the buffer is full
+func (s *scrollback) Write(p []byte) (int, error) {
+	n := len(p)
+	if n >= scrollbackSize {
+		s.buf = append(s.buf[:0], p[n-scrollbackSize:]...)
+		return n, nil
+	}
+	if overflow := len(s.buf) + n - scrollbackSize; overflow > 0 {
+		s.buf = append(s.buf[:0], s.buf[overflow:]...)
+	}
+	s.buf = append(s.buf, p...)
+	return n, nil
+}
+
+// Bytes returns the buffered output
+func (s *scrollback) Bytes() []byte {
+	return s.buf
+}
@@ -0,0 +1,635 @@
+package terminal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/gorilla/websocket"
+
+	"github.com/adaptive-scale/webshell/internal/auth"
+	"github.com/adaptive-scale/webshell/internal/cgroup"
+	"github.com/adaptive-scale/webshell/internal/config"
+	"github.com/adaptive-scale/webshell/internal/process"
+	"github.com/adaptive-scale/webshell/internal/recording"
+)
+
+var errSessionClosed = errors.New("terminal session is closed")
+
+// Terminal size limits
+const (
+	defaultCols = 80
+	defaultRows = 24
+	maxSize     = 4096
+)
+
+// terminalType is the TERM value advertised to shells, matching xterm.js
+const terminalType = "xterm-256color"
+
+// resizeDebounce is how long resize requests are coalesced before the PTY is
+// resized, so dragging a window does not flood the shell with SIGWINCH
+const resizeDebounce = 50 * time.Millisecond
+
+// TerminalSession represents a shell running on a PTY. A session outlives
+// individual WebSocket connections: when the last client goes away the session
+// is detached and kept alive for the configured grace period so it can be
+// resumed. Additional clients can join through share links.
+type TerminalSession struct {
+	id  string
+	cmd *exec.Cmd
+	pty *os.File
+
+	owner string
+	runAs string
+	// shell holds the options the shell was started with, so clients joining
+	// through share links can be checked against the policy
+	shell      shellOptions
+	remoteAddr string
+	userAgent  string
+	startedAt  time.Time
+
+	bytesIn   atomic.Int64
+	bytesOut  atomic.Int64
+	framesOut atomic.Int64
+
+	// lastActivity is the time of the last input or output in Unix nanoseconds
+	lastActivity atomic.Int64
+
+	// recorder is nil when recording is disabled
+	recorder *recording.Recorder
+
+	// release frees the session's slot in limits.Sessions
+	release func()
+	// group is the shell's cgroup, nil when cgroups are disabled
+	group *cgroup.Group
+	// stopped is cancelled by stop to make wait() terminate the shell
+	stopped context.Context
+	stop    context.CancelFunc
+
+	mu          sync.Mutex
+	flow        *sync.Cond // signalled when a viewer acknowledges output or leaves
+	viewers     map[*viewer]struct{}
+	shares      map[string]string // share key -> mode
+	scrollback  scrollback
+	orphanTimer *time.Timer
+	detachedAt  time.Time
+	cols, rows  int
+	closed      bool
+
+	// traffic of viewers that have left
+	pastTraffic traffic
+
+	// Close code and reason sent to clients when the server ends the
+	// session, instead of the shell exiting on its own
+	endCode   int
+	endReason string
+
+	// Pending size of a debounced resize
+	resizeTimer              *time.Timer
+	pendingCols, pendingRows int
+
+	pumpDone chan struct{}
+	done     chan struct{}
+}
+
+// exitStatus is sent to clients once the shell has exited. ExitCode is -1 when
+// the shell was killed by a signal. Reason is set when the server ended the
+// session.
+type exitStatus struct {
+	ExitCode int    `json:"exit_code"`
+	Signal   string `json:"signal,omitempty"`
+	Duration string `json:"duration"`
+	Reason   string `json:"reason,omitempty"`
+	// Cgroup reports OOM and limit events when cgroups are configured
+	Cgroup *cgroup.Events `json:"cgroup,omitempty"`
+}
+
+// sessionMessage tells the client which session it is attached to
+type sessionMessage struct {
+	Type    string `json:"type"`
+	ID      string `json:"id"`
+	Resumed bool   `json:"resumed"`
+	Mode    string `json:"mode"`
+}
+
+// presenceMessage lists the clients attached to the session, oldest first
+type presenceMessage struct {
+	Viewers []ViewerInfo `json:"viewers"`
+}
+
+// newSession starts a shell for the client making r and registers the
+// session. The PTY gets the requested size before the shell is spawned so the
+// first prompt renders correctly. release frees the client's slot in
+// limits.Sessions; the session calls it when it ends, or right away if the
+// shell cannot be started.
+func newSession(r *http.Request, cols, rows int, opts shellOptions, release func()) (*TerminalSession, error) {
+	id, err := newSessionID()
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	ts := &TerminalSession{
+		id:         id,
+		owner:      auth.Principal(r),
+		remoteAddr: auth.ClientIP(r),
+		userAgent:  r.UserAgent(),
+		startedAt:  time.Now(),
+		cols:       cols,
+		rows:       rows,
+		viewers:    make(map[*viewer]struct{}),
+		shares:     make(map[string]string),
+		release:    release,
+		pumpDone:   make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	ts.flow = sync.NewCond(&ts.mu)
+	ts.stopped, ts.stop = context.WithCancel(context.Background())
+	ts.shell = opts
+	if opts.runAs != nil {
+		ts.runAs = opts.runAs.Username
+	}
+	ts.touch()
+	if err := ts.startShell(opts); err != nil {
+		release()
+		return nil, err
+	}
+
+	if config.RecordingEnabled() {
+		rec, err := recording.Start(ts.id, ts.owner, cols, rows, "webshell@"+config.GetHostname(), map[string]string{
+			"SHELL": ts.cmd.Path,
+			"TERM":  terminalType,
+		})
+		if err != nil {
+			log.Printf("Failed to start recording for terminal session %s: %v", ts.id, err)
+		} else {
+			ts.recorder = rec
+		}
+	}
+
+	registry.add(ts)
+	go ts.pump()
+	go ts.wait()
+	go ts.watch()
+
+	log.Printf("Terminal session %s started (pid %d)", ts.id, ts.cmd.Process.Pid)
+	return ts, nil
+}
+
+// startShell starts a new shell process with PTY
+func (ts *TerminalSession) startShell(opts shellOptions) error {
+	ts.cmd = exec.Command(opts.path, opts.argv()...)
+	ts.cmd.Dir = opts.workDir()
+
+	// Set environment variables for proper terminal support; extra
+	// variables come last so they take precedence
+	ts.cmd.Env = append(process.Environ(opts.runAs),
+		"TERM="+terminalType,
+		"TERMINFO=/usr/share/terminfo",
+		"SHELL="+opts.path,
+	)
+	process.Apply(ts.cmd, opts.runAs)
+	ts.cmd.Env = append(ts.cmd.Env, opts.env...)
+
+	group, err := cgroup.New("session-" + ts.id)
+	if err != nil {
+		return fmt.Errorf("failed to create cgroup: %w", err)
+	}
+
+	// Create PTY, starting the shell in its cgroup
+	var ptyFile *os.File
+	err = group.Start(ts.cmd, func() (err error) {
+		ptyFile, err = pty.StartWithSize(ts.cmd, &pty.Winsize{
+			Cols: uint16(ts.cols),
+			Rows: uint16(ts.rows),
+		})
+		return err
+	})
+	if err != nil {
+		if ptyFile != nil {
+			ptyFile.Close()
+		}
+		group.Close()
+		return err
+	}
+	ts.pty = ptyFile
+	ts.group = group
+
+	log.Printf("PTY created with size %dx%d running %s", ts.cols, ts.rows, strings.Join(ts.cmd.Args, " "))
+
+	return nil
+}
+
+// attach adds v to the session. An owner replaces any previous owner
+// connection, so a client that reconnects before the old socket timed out
+// takes over cleanly. When replay is set, the buffered scrollback is sent
+// after the session message.
+func (ts *TerminalSession) attach(v *viewer, replay bool) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.closed {
+		return errSessionClosed
+	}
+
+	if ts.orphanTimer != nil {
+		ts.orphanTimer.Stop()
+		ts.orphanTimer = nil
+	}
+
+	if v.mode == ModeOwner {
+		for other := range ts.viewers {
+			if other.mode == ModeOwner {
+				log.Printf("Terminal session %s taken over by a new connection", ts.id)
+				ts.detachLocked(other, websocket.ClosePolicyViolation, "session attached elsewhere")
+			}
+		}
+	}
+
+	msg, err := json.Marshal(sessionMessage{Type: "session", ID: ts.id, Resumed: replay, Mode: v.mode})
+	if err != nil {
+		return err
+	}
+	v.enqueue(message{op: OpSession, data: msg})
+	if replay {
+		if buffered := ts.scrollback.Bytes(); len(buffered) > 0 {
+			v.enqueue(message{op: OpOutput, data: append([]byte(nil), buffered...)})
+			v.sent += int64(len(buffered))
+		}
+	}
+
+	ts.viewers[v] = struct{}{}
+	go v.writeLoop()
+	ts.presenceLocked()
+	return nil
+}
+
+// detach removes v from the session and starts the orphan timer when no
+// clients are left
+func (ts *TerminalSession) detach(v *viewer) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.detachLocked(v, websocket.CloseNormalClosure, "")
+}
+
+func (ts *TerminalSession) detachLocked(v *viewer, code int, reason string) {
+	if _, ok := ts.viewers[v]; !ok {
+		return
+	}
+	delete(ts.viewers, v)
+	ts.pastTraffic.add(v.traffic())
+	v.closeCode, v.closeReason = code, reason
+	close(v.send)
+	ts.flow.Broadcast()
+
+	if len(ts.viewers) > 0 || ts.closed {
+		if !ts.closed {
+			ts.presenceLocked()
+		}
+		return
+	}
+	ts.detachedAt = time.Now()
+
+	grace := config.GetSessionGracePeriod()
+	if grace <= 0 {
+		go ts.cleanup()
+		return
+	}
+
+	log.Printf("Terminal session %s detached, terminating in %s unless resumed", ts.id, grace)
+	ts.orphanTimer = time.AfterFunc(grace, func() {
+		ts.mu.Lock()
+		orphaned := len(ts.viewers) == 0
+		ts.mu.Unlock()
+		if orphaned {
+			log.Printf("Terminal session %s was not resumed, terminating", ts.id)
+			ts.cleanup()
+		}
+	})
+}
+
+// broadcast queues a message for every viewer, dropping viewers that cannot
+// keep up. Must be called with the lock held.
+func (ts *TerminalSession) broadcast(msg message) {
+	for v := range ts.viewers {
+		if msg.op == OpOutput {
+			v.sent += int64(len(msg.data))
+		}
+		if !v.enqueue(msg) {
+			log.Printf("Terminal session %s: viewer %s is too slow, disconnecting", ts.id, v.remoteAddr)
+			v.conn.Close()
+			ts.detachLocked(v, websocket.CloseTryAgainLater, "viewer too slow")
+		}
+	}
+}
+
+// presenceLocked tells every viewer who is attached. Must be called with the
+// lock held.
+func (ts *TerminalSession) presenceLocked() {
+	data, err := json.Marshal(presenceMessage{Viewers: ts.viewerInfosLocked()})
+	if err != nil {
+		return
+	}
+	ts.broadcast(message{op: OpPresence, data: data})
+}
+
+// pump reads from the PTY for the lifetime of the session, keeping the
+// scrollback up to date and forwarding output to all viewers in coalesced
+// frames
+func (ts *TerminalSession) pump() {
+	defer close(ts.pumpDone)
+
+	chunks := make(chan []byte, outputQueueSize)
+	go ts.read(chunks)
+	coalesce(chunks, ts.emit)
+}
+
+// read reads the PTY until it is closed. Sending blocks while the output
+// queue is full, so a paused session stops reading and the shell blocks on
+// its writes.
+func (ts *TerminalSession) read(chunks chan<- []byte) {
+	defer close(chunks)
+
+	buffer := make([]byte, readBufferSize)
+	for {
+		n, err := ts.pty.Read(buffer)
+		if n > 0 {
+			ts.touch()
+			ts.bytesOut.Add(int64(n))
+			if ts.recorder != nil {
+				ts.recorder.Output(buffer[:n])
+			}
+			chunks <- append([]byte(nil), buffer[:n]...)
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Error reading from PTY: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// emit sends one frame of output to all viewers, then waits until every
+// flow-controlled viewer is within its acknowledgement window
+func (ts *TerminalSession) emit(output []byte) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.framesOut.Add(1)
+	ts.scrollback.Write(output)
+	ts.broadcast(message{op: OpOutput, data: output})
+	if title, ok := titleFromOutput(output); ok {
+		ts.broadcast(message{op: OpTitle, data: []byte(title)})
+	}
+
+	for !ts.closed && ts.behind() {
+		ts.flow.Wait()
+	}
+}
+
+// behind reports whether a viewer that takes part in flow control has more
+// than ackWindow bytes of output unacknowledged. Read-only viewers cannot
+// hold up the shell. Must be called with the lock held.
+func (ts *TerminalSession) behind() bool {
+	for v := range ts.viewers {
+		if v.flowControl && v.canWrite() && v.sent-v.acked > ackWindow {
+			return true
+		}
+	}
+	return false
+}
+
+// ack records that v has processed n more bytes of output. The first
+// acknowledgement enables flow control for the viewer.
+func (ts *TerminalSession) ack(v *viewer, n int) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	v.flowControl = true
+	v.acked += int64(n)
+	if v.acked > v.sent {
+		v.acked = v.sent
+	}
+	ts.flow.Broadcast()
+}
+
+// wait blocks until the shell exits or the session is stopped, then tears the
+// session down
+func (ts *TerminalSession) wait() {
+	// Background jobs outlive the shell and keep the PTY open, they are
+	// ended before the shell is reaped
+	process.Wait(ts.stopped, ts.cmd, config.GetKillGracePeriod())
+
+	state := ts.cmd.ProcessState
+	exit := exitStatus{
+		ExitCode: state.ExitCode(),
+		Signal:   process.ExitSignal(state),
+		Duration: time.Since(ts.startedAt).Round(time.Millisecond).String(),
+	}
+
+	exit.Cgroup = ts.group.Events()
+	if err := ts.group.Close(); err != nil {
+		log.Printf("Failed to remove cgroup of terminal session %s: %v", ts.id, err)
+	}
+
+	// Give the pump a moment to flush any remaining output
+	select {
+	case <-ts.pumpDone:
+	case <-time.After(2 * time.Second):
+	}
+
+	ts.mu.Lock()
+	ts.closed = true
+	ts.flow.Broadcast()
+	code, reason := websocket.CloseNormalClosure, exit.describe()
+	if ts.endReason != "" {
+		code, reason = ts.endCode, ts.endReason
+		exit.Reason = ts.endReason
+	}
+	status, _ := json.Marshal(exit)
+	if ts.orphanTimer != nil {
+		ts.orphanTimer.Stop()
+		ts.orphanTimer = nil
+	}
+	if ts.resizeTimer != nil {
+		ts.resizeTimer.Stop()
+	}
+	ts.broadcast(message{op: OpExit, data: status})
+	for v := range ts.viewers {
+		ts.detachLocked(v, code, reason)
+	}
+	ts.mu.Unlock()
+
+	ts.pty.Close()
+	if ts.recorder != nil {
+		ts.recorder.Close()
+	}
+	registry.remove(ts.id)
+	ts.release()
+	close(ts.done)
+
+	if exit.Cgroup != nil && exit.Cgroup.OOMKills > 0 {
+		reason += fmt.Sprintf(", %d processes killed for running out of memory", exit.Cgroup.OOMKills)
+	}
+	log.Printf("Terminal session %s ended: %s after %s", ts.id, reason, exit.Duration)
+}
+
+// describe returns a short description of how the shell ended
+func (e exitStatus) describe() string {
+	if e.Signal != "" {
+		return "shell killed by " + e.Signal
+	}
+	return fmt.Sprintf("shell exited with code %d", e.ExitCode)
+}
+
+// terminate ends the session on behalf of the server. The first reason given
+// is sent to clients in the exit message and close frame.
+func (ts *TerminalSession) terminate(code int, reason string) {
+	ts.mu.Lock()
+	if ts.endReason == "" {
+		ts.endCode, ts.endReason = code, reason
+	}
+	ts.mu.Unlock()
+	ts.cleanup()
+}
+
+// handle reads from the viewer's WebSocket and acts on its messages until the
+// connection goes away or stops answering pings. Input from read-only viewers
+// is discarded and only the owner may resize the terminal.
+func (ts *TerminalSession) handle(v *viewer) {
+	v.keepAlive()
+	for {
+		messageType, data, err := v.conn.ReadMessage()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				log.Printf("Terminal session %s: no response from %s, disconnecting", ts.id, v.remoteAddr)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("WebSocket error: %v", err)
+			}
+			return
+		}
+		v.extendDeadline()
+		v.payloadIn.Add(int64(len(data)))
+
+		msg, err := v.proto.decode(messageType, data)
+		if err != nil {
+			log.Printf("Terminal session %s: ignoring message from %s: %v", ts.id, v.remoteAddr, err)
+			continue
+		}
+
+		switch msg.op {
+		case OpResize:
+			if v.mode == ModeOwner {
+				ts.resize(msg.cols, msg.rows)
+			}
+
+		case OpPing:
+			ts.sendTo(v, message{op: OpPong, data: msg.data})
+
+		case OpAck:
+			ts.ack(v, msg.acked)
+
+		case OpInput:
+			if !v.canWrite() {
+				continue
+			}
+			ts.touch()
+			if ts.recorder != nil {
+				ts.recorder.Input(msg.data)
+			}
+
+			// Write to PTY
+			n, err := ts.pty.Write(msg.data)
+			ts.bytesIn.Add(int64(n))
+			if err != nil {
+				log.Printf("Error writing to PTY: %v", err)
+				return
+			}
+		}
+	}
+}
+
+// resize schedules a PTY size change. Requests arriving within
+// resizeDebounce are coalesced and only the last size is applied. The size is
+// changed out-of-band with TIOCSWINSZ, which delivers SIGWINCH to the
+// foreground process; nothing is written to the shell.
+func (ts *TerminalSession) resize(cols, rows int) {
+	if cols <= 0 || rows <= 0 || cols > maxSize || rows > maxSize {
+		log.Printf("Terminal session %s: ignoring invalid size %dx%d", ts.id, cols, rows)
+		return
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.pendingCols, ts.pendingRows = cols, rows
+	if ts.resizeTimer == nil {
+		ts.resizeTimer = time.AfterFunc(resizeDebounce, ts.applyResize)
+	} else {
+		ts.resizeTimer.Reset(resizeDebounce)
+	}
+}
+
+// applyResize applies the pending size to the PTY
+func (ts *TerminalSession) applyResize() {
+	ts.mu.Lock()
+	cols, rows := ts.pendingCols, ts.pendingRows
+	changed := cols != ts.cols || rows != ts.rows
+	ts.cols, ts.rows = cols, rows
+	ts.mu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	if err := pty.Setsize(ts.pty, &pty.Winsize{
+		Rows: uint16(rows),
+		Cols: uint16(cols),
+	}); err != nil {
+		log.Printf("Error resizing PTY: %v", err)
+		return
+	}
+	if ts.recorder != nil {
+		ts.recorder.Resize(cols, rows)
+	}
+}
+
+// sendTo queues a message for a single viewer if it is still attached
+func (ts *TerminalSession) sendTo(v *viewer, msg message) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if _, ok := ts.viewers[v]; ok {
+		v.enqueue(msg)
+	}
+}
+
+// cleanup makes wait() terminate the shell and everything it started, then
+// take care of the rest
+func (ts *TerminalSession) cleanup() {
+	ts.stop()
+}
+
+// closeConn sends a close frame and closes the connection
+func closeConn(conn *websocket.Conn, code int, reason string) {
+	// Close frame payloads are limited to 125 bytes including the code
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(time.Second))
+	conn.Close()
+}
@@ -0,0 +1,96 @@
+package terminal
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/adaptive-scale/webshell/internal/auth"
+	"github.com/adaptive-scale/webshell/internal/process"
+)
+
+var (
+	// ErrSessionNotFound is returned when a session ID is unknown
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrInvalidShareMode is returned for share modes other than read-only and read-write
+	ErrInvalidShareMode = errors.New("share mode must be read-only or read-write")
+)
+
+// ShareSession creates a share key that lets additional clients join the
+// session with the given mode
+func ShareSession(id, mode string) (string, error) {
+	if mode != ModeReadOnly && mode != ModeReadWrite {
+		return "", ErrInvalidShareMode
+	}
+	ts, ok := registry.get(id)
+	if !ok {
+		return "", ErrSessionNotFound
+	}
+
+	key, err := newSessionID()
+	if err != nil {
+		return "", err
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.closed {
+		return "", ErrSessionNotFound
+	}
+	ts.shares[key] = mode
+
+	log.Printf("Terminal session %s shared (%s)", id, mode)
+	return key, nil
+}
+
+// RevokeShares invalidates all share keys of a session and disconnects the
+// viewers that joined through them
+func RevokeShares(id string) error {
+	ts, ok := registry.get(id)
+	if !ok {
+		return ErrSessionNotFound
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.shares = make(map[string]string)
+	for v := range ts.viewers {
+		if v.shareKey != "" {
+			ts.detachLocked(v, websocket.ClosePolicyViolation, "share revoked")
+		}
+	}
+
+	log.Printf("Terminal session %s shares revoked", id)
+	return nil
+}
+
+// findShare looks up the session and mode a share key grants access to
+func (r *sessionRegistry) findShare(key string) (*TerminalSession, string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, ts := range r.sessions {
+		ts.mu.Lock()
+		mode, ok := ts.shares[key]
+		ts.mu.Unlock()
+		if ok {
+			return ts, mode, true
+		}
+	}
+	return nil, "", false
+}
+
+// sameRunAs reports whether processes started by r would run as the same
+// user as the session's shell
+func (ts *TerminalSession) sameRunAs(r *http.Request) bool {
+	runAs, err := process.LookupUser(auth.RunAs(r))
+	if err != nil {
+		return false
+	}
+	name := ""
+	if runAs != nil {
+		name = runAs.Username
+	}
+	return name == ts.runAs
+}
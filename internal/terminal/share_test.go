@@ -0,0 +1,88 @@
+package terminal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adaptive-scale/webshell/internal/config"
+	"github.com/gorilla/websocket"
+)
+
+// dial opens a framed terminal connection to server with token
+func dial(t *testing.T, server *httptest.Server, query, token string) *websocket.Conn {
+	t.Helper()
+	dialer := websocket.Dialer{Subprotocols: []string{Subprotocol}}
+	header := http.Header{"X-Auth-Token": {token}}
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?" + query
+	conn, _, err := dialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("dial %s: %v", query, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// nextFrame reads frames from conn until one with op arrives
+func nextFrame(t *testing.T, conn *websocket.Conn, op byte) []byte {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("waiting for frame 0x%02x: %v", op, err)
+		}
+		if len(data) > 0 && data[0] == op {
+			return data[1:]
+		}
+	}
+}
+
+func TestShareViewerGetsPresence(t *testing.T) {
+	config.SetAuthToken("owner-token")
+	config.SetShell("/bin/sh", nil)
+	t.Cleanup(func() { config.SetAuthToken("") })
+
+	server := httptest.NewServer(http.HandlerFunc(WebSocket))
+	defer server.Close()
+
+	owner := dial(t, server, "", "owner-token")
+	var session sessionMessage
+	if err := json.Unmarshal(nextFrame(t, owner, OpSession), &session); err != nil {
+		t.Fatal(err)
+	}
+	defer KillSession(session.ID, "session terminated")
+
+	key, err := ShareSession(session.ID, ModeReadOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	viewer := dial(t, server, "share="+key, "viewer-token")
+
+	var presence presenceMessage
+	if err := json.Unmarshal(nextFrame(t, viewer, OpPresence), &presence); err != nil {
+		t.Fatal(err)
+	}
+	if len(presence.Viewers) != 2 {
+		t.Fatalf("viewer got %d viewers, want 2: %+v", len(presence.Viewers), presence.Viewers)
+	}
+	if presence.Viewers[0].Mode != ModeOwner || presence.Viewers[1].Mode != ModeReadOnly {
+		t.Errorf("viewer got modes %s and %s", presence.Viewers[0].Mode, presence.Viewers[1].Mode)
+	}
+	if presence.Viewers[0].Principal == presence.Viewers[1].Principal {
+		t.Errorf("owner and viewer share the principal %s", presence.Viewers[0].Principal)
+	}
+
+	// The owner hears about the viewer joining and leaving
+	nextFrame(t, owner, OpPresence)
+	if err := json.Unmarshal(nextFrame(t, owner, OpPresence), &presence); err != nil || len(presence.Viewers) != 2 {
+		t.Fatalf("owner got %+v, %v after the viewer joined", presence.Viewers, err)
+	}
+	viewer.Close()
+	if err := json.Unmarshal(nextFrame(t, owner, OpPresence), &presence); err != nil || len(presence.Viewers) != 1 {
+		t.Fatalf("owner got %+v, %v after the viewer left", presence.Viewers, err)
+	}
+}
@@ -0,0 +1,125 @@
+package terminal
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/adaptive-scale/webshell/internal/auth"
+	"github.com/adaptive-scale/webshell/internal/config"
+	"github.com/adaptive-scale/webshell/internal/policy"
+	"github.com/adaptive-scale/webshell/internal/process"
+)
+
+// shellOptions describes how the shell of a new session is started
+type shellOptions struct {
+	path  string
+	args  []string
+	login bool
+	dir   string
+	env   []string
+	runAs *process.Credential
+}
+
+// shellOptionsFromRequest builds shell options from the server configuration,
+// overridden by the shell, arg, login, cwd and env query parameters. The
+// shell must be on the allowlist and allowed by the command policy. The shell
+// runs as the request's run-as user.
+func shellOptionsFromRequest(r *http.Request) (shellOptions, error) {
+	query := r.URL.Query()
+	path, args := config.GetShell()
+	opts := shellOptions{
+		path:  path,
+		args:  args,
+		login: config.GetLoginShell(),
+		dir:   config.GetShellDir(),
+		env:   config.GetShellEnv(),
+	}
+
+	runAs, err := process.LookupUser(auth.RunAs(r))
+	if err != nil {
+		return opts, fmt.Errorf("failed to resolve run-as user: %v", err)
+	}
+	opts.runAs = runAs
+
+	if shell := query.Get("shell"); shell != "" {
+		if !config.IsShellAllowed(shell) {
+			return opts, fmt.Errorf("shell %q is not allowed", shell)
+		}
+		opts.path = shell
+		opts.args = nil
+	}
+	if args, ok := query["arg"]; ok {
+		opts.args = args
+	}
+	if login := query.Get("login"); login != "" {
+		enabled, err := strconv.ParseBool(login)
+		if err != nil {
+			return opts, fmt.Errorf("invalid login value %q", login)
+		}
+		opts.login = enabled
+	}
+	if dir := query.Get("cwd"); dir != "" {
+		// Checked as the shell's user, through "." so that search permission
+		// on the directory is needed too
+		err := process.AsUser(runAs, func() error {
+			_, err := os.Stat(dir + "/.")
+			return err
+		})
+		if err != nil {
+			return opts, fmt.Errorf("working directory %q does not exist or cannot be entered", dir)
+		}
+		opts.dir = dir
+	}
+	for _, kv := range query["env"] {
+		if !strings.Contains(kv, "=") || strings.HasPrefix(kv, "=") {
+			return opts, fmt.Errorf("invalid environment variable %q, expected KEY=VALUE", kv)
+		}
+		opts.env = append(opts.env, kv)
+	}
+
+	if err := opts.checkPolicy(r); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// checkPolicy evaluates the shell for the client making r, which may start it
+// or join a session running it, and fails if the policy denies it
+func (opts shellOptions) checkPolicy(r *http.Request) error {
+	decision := policy.Evaluate(policy.Request{
+		Source:  policy.SourceTerminal,
+		Token:   auth.TokenName(r),
+		Roles:   auth.Roles(r),
+		Command: opts.path,
+		Args:    opts.argv(),
+		Dir:     opts.workDir(),
+		Env:     opts.env,
+	})
+	if !decision.Allowed {
+		return fmt.Errorf("denied by policy: %s", decision.Reason)
+	}
+	return nil
+}
+
+// workDir returns the directory the shell starts in: the configured or
+// requested one, otherwise the run-as user's home if it exists, otherwise the
+// server's working directory (empty)
+func (opts shellOptions) workDir() string {
+	if opts.dir == "" && opts.runAs != nil {
+		if info, err := os.Stat(opts.runAs.Home); err == nil && info.IsDir() {
+			return opts.runAs.Home
+		}
+	}
+	return opts.dir
+}
+
+// argv returns the shell arguments, with -l first for login shells
+func (opts shellOptions) argv() []string {
+	if opts.login {
+		return append([]string{"-l"}, opts.args...)
+	}
+	return opts.args
+}
@@ -1,178 +1,169 @@
 package terminal
 
 import (
-	"encoding/json"
-	"fmt"
-	"io"
 	"log"
 	"net/http"
-	"os"
-	"os/exec"
+	"net/url"
+	"strconv"
 
 	"github.com/gorilla/websocket"
-	"github.com/creack/pty"
+
+	"github.com/adaptive-scale/webshell/internal/auth"
+	"github.com/adaptive-scale/webshell/internal/config"
+	"github.com/adaptive-scale/webshell/internal/limits"
 )
 
-// WebSocket upgrader
+// WebSocket upgrader; compression is enabled per connection by upgrade(). A
+// read buffer size is set so the upgrader reads through the counting
+// connection rather than reusing the server's buffered reader.
 var upgrader = websocket.Upgrader{
+	ReadBufferSize: 4096,
+	Subprotocols:   []string{Subprotocol},
 	CheckOrigin: func(r *http.Request) bool {
 		return true // Allow all origins for development
 	},
 }
 
-// TerminalSession represents a WebSocket terminal session
-type TerminalSession struct {
-	conn   *websocket.Conn
-	cmd    *exec.Cmd
-	pty    *os.File
-}
-
-// handleWebSocket handles WebSocket connections for the terminal
+// WebSocket handles WebSocket connections for the terminal. A new shell is
+// started unless the client asks to resume an existing one with ?session=<id>
+// or to join a shared one with ?share=<key>. New shells are sized from the
+// ?cols= and ?rows= parameters and may pick an allowed shell, arguments, login
+// mode, working directory and extra environment (see shellOptionsFromRequest).
 func WebSocket(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	
+
+	query := r.URL.Query()
+
+	// A new shell needs a session slot and joining through a share link a
+	// viewer slot. They are taken before the upgrade, so clients over a limit
+	// get a plain 429 that they and proxies understand.
+	var release func()
+	defer func() {
+		if release != nil {
+			release()
+		}
+	}()
+	limiter := limits.Sessions
+	if query.Get("share") != "" {
+		limiter = limits.Viewers
+	}
+	_, resuming := registry.get(query.Get("session"))
+	if limiter == limits.Viewers || !resuming {
+		var err error
+		release, err = limiter.TryAcquire(auth.Principal(r), auth.ClientIP(r))
+		if err != nil {
+			log.Printf("Refusing terminal session from %s: %v", auth.ClientIP(r), err)
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+	}
+
 	// Upgrade HTTP connection to WebSocket
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, wire, compressed, err := upgrade(w, r)
 	if err != nil {
 		log.Printf("Failed to upgrade connection: %v", err)
 		return
 	}
 	defer conn.Close()
-	
-	// Create a new terminal session
-	session := &TerminalSession{
-		conn: conn,
-	}
-	
-	// Start the shell process
-	if err := session.startShell(); err != nil {
-		log.Printf("Failed to start shell: %v", err)
-		conn.WriteMessage(websocket.TextMessage, []byte("Error: Failed to start shell\r\n"))
+
+	// Clients must negotiate the framed protocol unless legacy mode is on
+	if conn.Subprotocol() != Subprotocol && !config.LegacyProtocolEnabled() {
+		closeConn(conn, websocket.CloseProtocolError, "unsupported protocol, negotiate "+Subprotocol)
 		return
 	}
-	defer session.cleanup()
-	
-	// Handle terminal session
-	session.handle()
-}
 
-// startShell starts a new shell process with PTY
-func (ts *TerminalSession) startShell() error {
-	// Start bash shell with proper environment
-	ts.cmd = exec.Command("bash")
-	
-	// Set environment variables for proper terminal support
-	ts.cmd.Env = append(os.Environ(),
-		"TERM=xterm",
-		"TERMINFO=/usr/share/terminfo",
-	)
-	
-	// Create PTY
-	ptyFile, err := pty.Start(ts.cmd)
-	if err != nil {
-		return err
+	// Join a shared session
+	if key := query.Get("share"); key != "" {
+		session, mode, ok := registry.findShare(key)
+		if !ok {
+			closeConn(conn, websocket.ClosePolicyViolation, "share link is invalid or expired")
+			return
+		}
+		// Typing into a shell acts as its user, so read-write links only
+		// admit tokens that run as that user themselves
+		if mode == ModeReadWrite && !auth.CanAccess(r, session.owner) && !session.sameRunAs(r) {
+			closeConn(conn, websocket.ClosePolicyViolation, "read-write share links can only be joined by tokens running as the session's user")
+			return
+		}
+		if err := session.shell.checkPolicy(r); err != nil {
+			log.Printf("Refusing to join terminal session %s: %v", session.id, err)
+			closeConn(conn, websocket.ClosePolicyViolation, err.Error())
+			return
+		}
+		serve(session, newViewer(conn, wire, compressed, r, mode, key), true)
+		return
 	}
-	ts.pty = ptyFile
-	
-	log.Printf("PTY created, waiting for client to set size")
-	
-	return nil
-}
 
-// handle manages the WebSocket communication
-func (ts *TerminalSession) handle() {
-	// Channel to signal when the command is done
-	done := make(chan bool)
-	
-	// Read from PTY and send to WebSocket
-	go func() {
-		buffer := make([]byte, 1024)
-		for {
-			n, err := ts.pty.Read(buffer)
-			if err != nil {
-				if err != io.EOF {
-					log.Printf("Error reading from PTY: %v", err)
-				}
-				break
+	// Resume the requested session if it is still alive
+	if id := query.Get("session"); id != "" {
+		if session, ok := registry.get(id); ok {
+			if session.owner != auth.Principal(r) {
+				closeConn(conn, websocket.ClosePolicyViolation, "session belongs to another user, use a share link")
+				return
 			}
-			if n > 0 {
-				err = ts.conn.WriteMessage(websocket.TextMessage, buffer[:n])
-				if err != nil {
-					log.Printf("Error writing to WebSocket: %v", err)
-					break
-				}
+			if serve(session, newViewer(conn, wire, compressed, r, ModeOwner, ""), true) != errSessionClosed {
+				return
 			}
+			// The shell exited between lookup and attach
+		} else {
+			log.Printf("Terminal session %s not found, starting a new one", id)
 		}
-		done <- true
-	}()
-	
-	// Read from WebSocket and write to PTY
-	go func() {
-		for {
-			_, message, err := ts.conn.ReadMessage()
-			if err != nil {
-				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-					log.Printf("WebSocket error: %v", err)
-				}
-				break
-			}
-			
-			// Check if it's a resize message
-			if len(message) > 0 && message[0] == '{' {
-				var resizeMsg struct {
-					Type string `json:"type"`
-					Cols int    `json:"cols"`
-					Rows int    `json:"rows"`
-				}
-				if err := json.Unmarshal(message, &resizeMsg); err == nil && resizeMsg.Type == "resize" {
-					// Resize the PTY
-					log.Printf("Resizing PTY to %dx%d", resizeMsg.Cols, resizeMsg.Rows)
-					if err := pty.Setsize(ts.pty, &pty.Winsize{
-						Rows: uint16(resizeMsg.Rows),
-						Cols: uint16(resizeMsg.Cols),
-					}); err != nil {
-						log.Printf("Error resizing PTY: %v", err)
-					} else {
-						log.Printf("Successfully resized PTY to %dx%d", resizeMsg.Cols, resizeMsg.Rows)
-						
-						// Update environment variables in the shell
-						colsStr := fmt.Sprintf("%d", resizeMsg.Cols)
-						rowsStr := fmt.Sprintf("%d", resizeMsg.Rows)
-						
-						// Send commands to update COLUMNS and LINES
-						updateCmd := fmt.Sprintf("export COLUMNS=%s; export LINES=%s; stty cols %s rows %s\n", 
-							colsStr, rowsStr, colsStr, rowsStr)
-						ts.pty.Write([]byte(updateCmd))
-					}
-					continue
-				}
-			}
-			
-			// Write to PTY
-			_, err = ts.pty.Write(message)
-			if err != nil {
-				log.Printf("Error writing to PTY: %v", err)
-				break
-			}
+	}
+
+	// Otherwise start a new shell, sized and configured as requested by the client
+	opts, err := shellOptionsFromRequest(r)
+	if err != nil {
+		log.Printf("Refusing terminal session: %v", err)
+		closeConn(conn, websocket.ClosePolicyViolation, err.Error())
+		return
+	}
+	if release == nil {
+		// The session to resume ended after the upgrade
+		release, err = limits.Sessions.TryAcquire(auth.Principal(r), auth.ClientIP(r))
+		if err != nil {
+			log.Printf("Refusing terminal session from %s: %v", auth.ClientIP(r), err)
+			closeConn(conn, websocket.CloseTryAgainLater, err.Error())
+			return
 		}
-		done <- true
-	}()
-	
-	// Wait for the command to finish
-	ts.cmd.Wait()
-	<-done
-	<-done
+	}
+	cols, rows := initialSize(query)
+	session, err := newSession(r, cols, rows, opts, release)
+	release = nil
+	if err != nil {
+		log.Printf("Failed to start shell: %v", err)
+		closeConn(conn, websocket.CloseInternalServerErr, "failed to start shell")
+		return
+	}
+	serve(session, newViewer(conn, wire, compressed, r, ModeOwner, ""), false)
 }
 
-// cleanup cleans up the terminal session
-func (ts *TerminalSession) cleanup() {
-	if ts.pty != nil {
-		ts.pty.Close()
+// serve attaches v to the session and handles its input until it disconnects
+func serve(session *TerminalSession, v *viewer, replay bool) error {
+	if err := session.attach(v, replay); err != nil {
+		log.Printf("Failed to attach to terminal session %s: %v", session.id, err)
+		return err
 	}
-	if ts.cmd != nil && ts.cmd.Process != nil {
-		ts.cmd.Process.Kill()
+	defer session.detach(v)
+
+	// Handle terminal session
+	session.handle(v)
+	return nil
+}
+
+// initialSize returns the terminal size requested with the cols and rows
+// query parameters, falling back to 80x24
+func initialSize(query url.Values) (int, int) {
+	cols, err := strconv.Atoi(query.Get("cols"))
+	if err != nil || cols <= 0 || cols > maxSize {
+		cols = defaultCols
 	}
-} 
\ No newline at end of file
+	rows, err := strconv.Atoi(query.Get("rows"))
+	if err != nil || rows <= 0 || rows > maxSize {
+		rows = defaultRows
+	}
+	return cols, rows
+}
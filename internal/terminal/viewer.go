@@ -0,0 +1,189 @@
+package terminal
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/adaptive-scale/webshell/internal/auth"
+	"github.com/adaptive-scale/webshell/internal/config"
+)
+
+// Viewer access modes
+const (
+	// ModeOwner is the client that started or resumed the session
+	ModeOwner = "owner"
+	// ModeReadWrite viewers joined through a share link and may type
+	ModeReadWrite = "read-write"
+	// ModeReadOnly viewers joined through a share link and only see output
+	ModeReadOnly = "read-only"
+)
+
+// viewerQueueSize is the number of messages buffered per viewer before it is
+// considered too slow and disconnected; the queue is also limited to
+// viewerQueueBytes
+const viewerQueueSize = 256
+
+// writeWait is how long a single write to a client may take before the
+// connection is considered dead
+const writeWait = 10 * time.Second
+
+// viewer is a WebSocket client attached to a session. Output is fanned out to
+// every viewer through its own queue so one slow client cannot stall the others.
+type viewer struct {
+	conn       *websocket.Conn
+	wire       *countingConn
+	compressed bool
+	proto      protocol
+	mode       string
+	shareKey   string
+	principal  string
+	remoteAddr string
+	joinedAt   time.Time
+
+	// send is closed by the session when the viewer is detached
+	send        chan message
+	queued      atomic.Int64 // bytes in send
+	payloadOut  atomic.Int64 // message bytes written, before compression
+	payloadIn   atomic.Int64 // message bytes read, after decompression
+	closeCode   int
+	closeReason string
+
+	// Output flow control, guarded by the session lock
+	flowControl bool
+	sent, acked int64
+}
+
+// newViewer creates a viewer for conn opened by r. wire counts the bytes of
+// the underlying connection and compressed tells if permessage-deflate was
+// negotiated.
+func newViewer(conn *websocket.Conn, wire *countingConn, compressed bool, r *http.Request, mode, shareKey string) *viewer {
+	return &viewer{
+		conn:       conn,
+		wire:       wire,
+		compressed: compressed,
+		proto:      protocolFor(conn),
+		mode:       mode,
+		shareKey:   shareKey,
+		principal:  auth.Principal(r),
+		remoteAddr: auth.ClientIP(r),
+		joinedAt:   time.Now(),
+		send:       make(chan message, viewerQueueSize),
+		closeCode:  websocket.CloseNormalClosure,
+	}
+}
+
+// canWrite reports whether the viewer may send input to the shell
+func (v *viewer) canWrite() bool {
+	return v.mode != ModeReadOnly
+}
+
+// enqueue queues a message without blocking. It reports false if the queue
+// is full. Must be called with the session lock held.
+func (v *viewer) enqueue(msg message) bool {
+	if v.queued.Load()+int64(len(msg.data)) > viewerQueueBytes {
+		return false
+	}
+	select {
+	case v.send <- msg:
+		v.queued.Add(int64(len(msg.data)))
+		return true
+	default:
+		return false
+	}
+}
+
+// writeLoop sends queued messages and keepalive pings until the queue is
+// closed, then sends a close frame with the recorded reason
+func (v *viewer) writeLoop() {
+	var ping <-chan time.Time
+	if interval := config.GetPingInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+
+	for {
+		select {
+		case msg, ok := <-v.send:
+			if !ok {
+				closeConn(v.conn, v.closeCode, v.closeReason)
+				return
+			}
+			v.queued.Add(-int64(len(msg.data)))
+			messageType, data, ok := v.proto.encode(msg)
+			if !ok {
+				continue
+			}
+			// Small frames barely compress, send them as they are
+			v.conn.EnableWriteCompression(len(data) >= config.GetCompressionThreshold())
+			v.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := v.conn.WriteMessage(messageType, data); err != nil {
+				log.Printf("Error writing to WebSocket: %v", err)
+				v.conn.Close()
+				return
+			}
+			v.payloadOut.Add(int64(len(data)))
+
+		case <-ping:
+			if err := v.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				log.Printf("Error pinging WebSocket client %s: %v", v.remoteAddr, err)
+				v.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// keepAlive makes reads fail once the client has not answered two pings, so
+// half-open connections are detected. Every pong or message from the client
+// extends the deadline.
+func (v *viewer) keepAlive() {
+	if config.GetPingInterval() <= 0 {
+		return
+	}
+	v.extendDeadline()
+	v.conn.SetPongHandler(func(string) error {
+		v.extendDeadline()
+		return nil
+	})
+}
+
+// extendDeadline pushes the read deadline out after hearing from the client
+func (v *viewer) extendDeadline() {
+	if interval := config.GetPingInterval(); interval > 0 {
+		v.conn.SetReadDeadline(time.Now().Add(2 * interval))
+	}
+}
+
+// traffic returns the bytes the viewer's connection has carried so far
+func (v *viewer) traffic() traffic {
+	return traffic{
+		uncompressedOut: v.payloadOut.Load(),
+		compressedOut:   v.wire.written.Load(),
+		uncompressedIn:  v.payloadIn.Load(),
+		compressedIn:    v.wire.read.Load(),
+	}
+}
+
+// ViewerInfo describes a client attached to a session
+type ViewerInfo struct {
+	Principal  string    `json:"principal"`
+	Mode       string    `json:"mode"`
+	RemoteAddr string    `json:"remote_addr"`
+	JoinedAt   time.Time `json:"joined_at"`
+	Compressed bool      `json:"compressed"`
+}
+
+func (v *viewer) info() ViewerInfo {
+	return ViewerInfo{
+		Principal:  v.principal,
+		Mode:       v.mode,
+		RemoteAddr: v.remoteAddr,
+		JoinedAt:   v.joinedAt.UTC(),
+		Compressed: v.compressed,
+	}
+}
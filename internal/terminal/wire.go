@@ -0,0 +1,104 @@
+package terminal
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/adaptive-scale/webshell/internal/config"
+)
+
+// countingConn counts the bytes a WebSocket connection sends and receives on
+// the wire, after compression and including frame headers
+type countingConn struct {
+	net.Conn
+	read    atomic.Int64
+	written atomic.Int64
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+// countingResponseWriter hands a countingConn to the upgrader when it hijacks
+// the connection
+type countingResponseWriter struct {
+	http.ResponseWriter
+	conn *countingConn
+}
+
+func (w *countingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not implement http.Hijacker")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.conn = &countingConn{Conn: conn}
+	return w.conn, rw, nil
+}
+
+// upgrade upgrades r to a WebSocket connection, negotiating permessage-deflate
+// at the configured level when the client supports it. It returns the wire
+// byte counters of the connection and whether compression was negotiated.
+func upgrade(w http.ResponseWriter, r *http.Request) (*websocket.Conn, *countingConn, bool, error) {
+	u := upgrader
+	level := config.GetCompressionLevel()
+	u.EnableCompression = level != 0
+
+	cw := &countingResponseWriter{ResponseWriter: w}
+	conn, err := u.Upgrade(cw, r, nil)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	compressed := u.EnableCompression && offersDeflate(r)
+	if compressed {
+		conn.SetCompressionLevel(level)
+	}
+	// Do not count the handshake response
+	cw.conn.written.Store(0)
+	return conn, cw.conn, compressed, nil
+}
+
+// offersDeflate reports whether the client offered permessage-deflate, which
+// the upgrader accepts whenever compression is enabled
+func offersDeflate(r *http.Request) bool {
+	for _, header := range r.Header.Values("Sec-WebSocket-Extensions") {
+		for _, ext := range strings.Split(header, ",") {
+			name, _, _ := strings.Cut(ext, ";")
+			if strings.EqualFold(strings.TrimSpace(name), "permessage-deflate") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// traffic counts WebSocket bytes before compression and on the wire
+type traffic struct {
+	uncompressedOut, compressedOut int64
+	uncompressedIn, compressedIn   int64
+}
+
+func (t *traffic) add(other traffic) {
+	t.uncompressedOut += other.uncompressedOut
+	t.compressedOut += other.compressedOut
+	t.uncompressedIn += other.uncompressedIn
+	t.compressedIn += other.compressedIn
+}
@@ -5,11 +5,20 @@ import (
 	"flag"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/adaptive-scale/webshell/internal/auth"
+	"github.com/adaptive-scale/webshell/internal/cgroup"
 	"github.com/adaptive-scale/webshell/internal/config"
 	"github.com/adaptive-scale/webshell/internal/handler"
+	"github.com/adaptive-scale/webshell/internal/jobs"
+	"github.com/adaptive-scale/webshell/internal/policy"
+	"github.com/adaptive-scale/webshell/internal/process"
+	"github.com/adaptive-scale/webshell/internal/recording"
+	"github.com/adaptive-scale/webshell/internal/size"
+	"github.com/adaptive-scale/webshell/internal/spill"
 	"github.com/adaptive-scale/webshell/internal/terminal"
 )
 
@@ -21,7 +30,55 @@ func main() {
 		securePath = flag.String("path", "", "Secure path prefix (default: empty or SECURE_PATH env, e.g., /abc123/)")
 		certFile   = flag.String("cert", "", "TLS certificate file (can also use CERT_FILE env)")
 		keyFile    = flag.String("key", "", "TLS private key file (can also use KEY_FILE env)")
+		tokensFile = flag.String("tokens-file", "", "JSON file with additional named tokens, their run-as users and roles (can also use TOKENS_FILE env)")
+		policyFile = flag.String("policy-file", "", "JSON file with the command policy: allow and deny rules for /execute, jobs and terminal shells; all commands are allowed when empty (can also use POLICY_FILE env)")
+		runAs      = flag.String("run-as", "", "Run shells and commands as this user[:group] (can also use RUN_AS env)")
+		grace      = flag.String("session-grace", "", "How long a disconnected terminal session is kept for resuming, 0 to kill immediately (default: 5m or SESSION_GRACE_PERIOD env)")
+		idle       = flag.String("idle-timeout", "", "Terminate terminal sessions without input or output for this long, 0 to disable (default: 0 or IDLE_TIMEOUT env)")
+		maxSession = flag.String("max-session-duration", "", "Terminate terminal sessions after running this long, 0 to disable (default: 0 or MAX_SESSION_DURATION env)")
+		warning    = flag.String("session-warning", "", "Warn terminal clients this long before an idle or maximum duration termination (default: 1m or SESSION_WARNING env)")
+		killGrace  = flag.String("kill-grace", "", "How long processes get to exit after SIGHUP and again after SIGTERM before SIGKILL (default: 2s or KILL_GRACE_PERIOD env)")
+		pingEvery  = flag.String("ws-ping-interval", "", "How often terminal WebSocket clients are pinged; clients that miss two pings are disconnected, 0 to disable (default: 30s or WS_PING_INTERVAL env)")
+		compLevel  = flag.String("ws-compression-level", "", "permessage-deflate level for terminal WebSockets, -2 (Huffman only) to 9, 0 disables compression (default: 1 or WS_COMPRESSION_LEVEL env)")
+		compMin    = flag.Int("ws-compression-threshold", 0, "Send terminal frames smaller than this many bytes uncompressed (default: 256 or WS_COMPRESSION_THRESHOLD env)")
+		maxSess    = flag.Int("max-sessions", 0, "Maximum concurrent terminal sessions, 0 for no limit (can also use MAX_SESSIONS env)")
+		maxSessTok = flag.Int("max-sessions-per-token", 0, "Maximum concurrent terminal sessions per token, 0 for no limit (can also use MAX_SESSIONS_PER_TOKEN env)")
+		maxSessIP  = flag.Int("max-sessions-per-ip", 0, "Maximum concurrent terminal sessions per client IP, 0 for no limit (can also use MAX_SESSIONS_PER_IP env)")
+		maxView    = flag.Int("max-viewers", 0, "Maximum concurrent share link connections, 0 for no limit (can also use MAX_VIEWERS env)")
+		maxViewTok = flag.Int("max-viewers-per-token", 0, "Maximum concurrent share link connections per token, 0 for no limit (can also use MAX_VIEWERS_PER_TOKEN env)")
+		maxViewIP  = flag.Int("max-viewers-per-ip", 0, "Maximum concurrent share link connections per client IP, 0 for no limit (can also use MAX_VIEWERS_PER_IP env)")
+		maxExec    = flag.Int("max-executions", 0, "Maximum concurrent /execute commands, 0 for no limit (can also use MAX_EXECUTIONS env)")
+		maxExecTok = flag.Int("max-executions-per-token", 0, "Maximum concurrent /execute commands per token, 0 for no limit (can also use MAX_EXECUTIONS_PER_TOKEN env)")
+		maxExecIP  = flag.Int("max-executions-per-ip", 0, "Maximum concurrent /execute commands per client IP, 0 for no limit (can also use MAX_EXECUTIONS_PER_IP env)")
+		execTime   = flag.String("execute-timeout", "", "How long /execute commands and jobs may run, 0 for no limit; requests may ask for less (default: 5m or EXECUTE_TIMEOUT env)")
+		failStat   = flag.Int("fail-status", 0, "HTTP status /execute answers with when a command exits non-zero, 0 for 200; timeouts answer 504 and start failures 500 (can also use FAIL_STATUS env)")
+		execQueue  = flag.String("execute-queue-timeout", "", "How long /execute waits for a free slot before answering 429, 0 to answer immediately (default: 0 or EXECUTE_QUEUE_TIMEOUT env)")
+		cgRoot     = flag.String("cgroup-root", "", "Delegated cgroup v2 directory under which every shell and command gets its own cgroup, cgroups are off when empty (can also use CGROUP_ROOT env)")
+		cgCPU      = flag.String("cgroup-cpu", "", "CPU quota per shell or command in CPUs, e.g. 0.5 (can also use CGROUP_CPU env)")
+		cgMemory   = flag.String("cgroup-memory", "", "Memory limit per shell or command, e.g. 512M (can also use CGROUP_MEMORY env)")
+		cgPids     = flag.String("cgroup-pids", "", "Maximum number of processes per shell or command (can also use CGROUP_PIDS env)")
+		cgIOWeight = flag.String("cgroup-io-weight", "", "IO weight per shell or command, 1 to 10000 (can also use CGROUP_IO_WEIGHT env)")
+		maxOutput  = flag.String("max-output", "", "Bytes of output an /execute response keeps, e.g. 10M, 0 for no limit; requests may ask for less (default: 10M or MAX_OUTPUT env)")
+		outputDir  = flag.String("output-dir", "", "Directory the full output of truncated commands is kept in for download, dropped when empty (can also use OUTPUT_DIR env)")
+		outputKeep = flag.String("output-retention", "", "How long full output can be downloaded (default: 15m or OUTPUT_RETENTION env)")
+		outputMax  = flag.String("output-spill-limit", "", "Bytes of full output written to disk per command, e.g. 1G, 0 for no limit (default: 1G or OUTPUT_SPILL_LIMIT env)")
+		jobsDir    = flag.String("jobs-dir", "", "Directory for job output and status, so finished jobs survive restarts; jobs are kept in memory when empty (can also use JOBS_DIR env)")
+		maxJobs    = flag.Int("max-jobs", 0, "Maximum jobs kept, running or finished; the oldest finished job is dropped first (default: 100 or MAX_JOBS env)")
+		jobKeep    = flag.String("job-retention", "", "How long finished jobs are kept, 0 to keep them until dropped for newer jobs (default: 1h or JOB_RETENTION env)")
+		jobOutMax  = flag.Int("job-output-limit", 0, "Bytes of output kept per job in memory or in -jobs-dir, older output is dropped (default: 1048576 or JOB_OUTPUT_LIMIT env)")
+		legacyWS   = flag.Bool("legacy-protocol", false, "Accept WebSocket clients using the old unframed text protocol (can also use LEGACY_PROTOCOL=true env)")
+		shell      = flag.String("shell", "", "Shell binary for terminal sessions (default: bash if installed, else /bin/sh, or TERMINAL_SHELL env)")
+		shellArgs  = flag.String("shell-args", "", "Space separated arguments for the shell (can also use TERMINAL_SHELL_ARGS env)")
+		login      = flag.Bool("login", false, "Start terminal shells as login shells with -l (can also use TERMINAL_LOGIN=true env)")
+		shellDir   = flag.String("cwd", "", "Initial working directory of terminal shells (can also use TERMINAL_CWD env)")
+		shells     = flag.String("allowed-shells", "", "Comma separated shells clients may request with ?shell= besides the default (can also use ALLOWED_SHELLS env)")
+		shellEnv   listFlag
+		recordDir  = flag.String("record-dir", "", "Directory for asciicast recordings of terminal sessions, recording is off when empty (can also use RECORDING_DIR env)")
+		recordIn   = flag.Bool("record-input", false, "Also record keystrokes in terminal recordings (can also use RECORD_INPUT=true env)")
+		recordAge  = flag.String("record-max-age", "", "Delete recordings older than this, 0 keeps them forever (can also use RECORDING_MAX_AGE env)")
+		recordMax  = flag.Int("record-max-files", 0, "Keep at most this many recordings, 0 for no limit (can also use RECORDING_MAX_FILES env)")
 	)
+	flag.Var(&shellEnv, "shell-env", "Extra KEY=VALUE environment variable for terminal shells, may be repeated (can also use comma separated TERMINAL_ENV env)")
 	flag.Parse()
 
 	// Get port from flag, env, or default
@@ -36,9 +93,35 @@ func main() {
 		token = config.GetEnv("AUTH_TOKEN", "")
 	}
 
+	// Load named tokens if provided
+	tokensPath := *tokensFile
+	if tokensPath == "" {
+		tokensPath = config.GetEnv("TOKENS_FILE", "")
+	}
+	if tokensPath != "" {
+		if err := config.LoadTokensFile(tokensPath); err != nil {
+			log.Fatalf("Failed to load tokens file: %v", err)
+		}
+		log.Printf("Loaded %d named tokens from %s", len(config.GetTokens()), tokensPath)
+	}
+
+	// Load the command policy if provided
+	policyPath := *policyFile
+	if policyPath == "" {
+		policyPath = config.GetEnv("POLICY_FILE", "")
+	}
+	if policyPath != "" {
+		if err := policy.Load(policyPath); err != nil {
+			log.Fatalf("Failed to load policy file: %v", err)
+		}
+		log.Printf("Loaded command policy with %d rules from %s", policy.RuleCount(), policyPath)
+	}
+
 	// Set auth token if provided
 	if token != "" {
 		config.SetAuthToken(token)
+	}
+	if config.HasAuthToken() {
 		log.Printf("Authentication enabled")
 	} else {
 		log.Printf("Warning: No authentication token set. Server is open to all requests.")
@@ -53,6 +136,170 @@ func main() {
 	// Normalize path prefix: ensure it starts with / and ends with /
 	pathPrefix = normalizePath(pathPrefix)
 
+	// Get terminal session grace period from flag or env
+	config.SetSessionGracePeriod(durationSetting(*grace, "SESSION_GRACE_PERIOD", config.GetSessionGracePeriod()))
+
+	// Get terminal session idle timeout, maximum duration and warning lead time from flags or env
+	config.SetIdleTimeout(durationSetting(*idle, "IDLE_TIMEOUT", config.GetIdleTimeout()))
+	config.SetMaxSessionDuration(durationSetting(*maxSession, "MAX_SESSION_DURATION", config.GetMaxSessionDuration()))
+	config.SetSessionWarning(durationSetting(*warning, "SESSION_WARNING", config.GetSessionWarning()))
+
+	// Get how long processes may take to exit when a session or command is terminated
+	config.SetKillGracePeriod(durationSetting(*killGrace, "KILL_GRACE_PERIOD", config.GetKillGracePeriod()))
+
+	// Get WebSocket keepalive interval from flag or env
+	config.SetPingInterval(durationSetting(*pingEvery, "WS_PING_INTERVAL", config.GetPingInterval()))
+
+	// Get WebSocket compression level and threshold from flags or env
+	level := *compLevel
+	if level == "" {
+		level = config.GetEnv("WS_COMPRESSION_LEVEL", "")
+	}
+	if level != "" {
+		n, err := strconv.Atoi(level)
+		if err != nil || n < -2 || n > 9 {
+			log.Fatalf("Invalid WebSocket compression level %q, must be between -2 and 9", level)
+		}
+		config.SetCompressionLevel(n)
+	}
+	config.SetCompressionThreshold(intSetting(*compMin, "WS_COMPRESSION_THRESHOLD", config.GetCompressionThreshold()))
+
+	// Get concurrency limits for terminal sessions, share viewers and command executions from flags or env
+	config.SetSessionLimits(config.Limits{
+		Global:   intSetting(*maxSess, "MAX_SESSIONS", 0),
+		PerToken: intSetting(*maxSessTok, "MAX_SESSIONS_PER_TOKEN", 0),
+		PerIP:    intSetting(*maxSessIP, "MAX_SESSIONS_PER_IP", 0),
+	})
+	config.SetViewerLimits(config.Limits{
+		Global:   intSetting(*maxView, "MAX_VIEWERS", 0),
+		PerToken: intSetting(*maxViewTok, "MAX_VIEWERS_PER_TOKEN", 0),
+		PerIP:    intSetting(*maxViewIP, "MAX_VIEWERS_PER_IP", 0),
+	})
+	config.SetExecutionLimits(config.Limits{
+		Global:   intSetting(*maxExec, "MAX_EXECUTIONS", 0),
+		PerToken: intSetting(*maxExecTok, "MAX_EXECUTIONS_PER_TOKEN", 0),
+		PerIP:    intSetting(*maxExecIP, "MAX_EXECUTIONS_PER_IP", 0),
+	})
+	config.SetExecuteTimeout(durationSetting(*execTime, "EXECUTE_TIMEOUT", config.GetExecuteTimeout()))
+	config.SetExecuteQueueTimeout(durationSetting(*execQueue, "EXECUTE_QUEUE_TIMEOUT", 0))
+	failStatus := intSetting(*failStat, "FAIL_STATUS", 0)
+	if failStatus != 0 && (failStatus < 400 || failStatus > 599) {
+		log.Fatalf("Invalid fail status %d, use a status code from 400 to 599", failStatus)
+	}
+	config.SetFailStatus(failStatus)
+
+	// Get cgroup settings from flags or env and prepare the delegated subtree
+	cgroupRoot := *cgRoot
+	if cgroupRoot == "" {
+		cgroupRoot = config.GetEnv("CGROUP_ROOT", "")
+	}
+	config.SetCgroup(cgroupRoot, config.CgroupLimits{
+		CPUMax:    cgroupSetting(*cgCPU, "CGROUP_CPU", cgroup.ParseCPU),
+		MemoryMax: cgroupSetting(*cgMemory, "CGROUP_MEMORY", cgroup.ParseBytes),
+		PidsMax: cgroupSetting(*cgPids, "CGROUP_PIDS", func(s string) (string, error) {
+			return cgroup.ParseRange(s, 1, 1<<22, true)
+		}),
+		IOWeight: cgroupSetting(*cgIOWeight, "CGROUP_IO_WEIGHT", func(s string) (string, error) {
+			if s, err := cgroup.ParseRange(s, 1, 10000, false); err != nil || s == "" {
+				return s, err
+			}
+			return "default " + s, nil
+		}),
+	})
+	if err := cgroup.Setup(); err != nil {
+		log.Fatalf("Failed to set up cgroups: %v", err)
+	}
+	if cgroupRoot != "" {
+		log.Printf("Running shells and commands in cgroups under %s", cgroupRoot)
+	} else if limits := config.GetCgroupLimits(); limits != (config.CgroupLimits{}) {
+		log.Fatal("Cgroup limits need a cgroup root, set -cgroup-root or CGROUP_ROOT")
+	}
+
+	// Get output limits from flags or env and prepare the output directory
+	config.SetMaxOutput(sizeSetting(*maxOutput, "MAX_OUTPUT", config.GetMaxOutput()))
+	outputDirectory := *outputDir
+	if outputDirectory == "" {
+		outputDirectory = config.GetEnv("OUTPUT_DIR", "")
+	}
+	config.SetOutputDir(outputDirectory)
+	config.SetOutputRetention(durationSetting(*outputKeep, "OUTPUT_RETENTION", config.GetOutputRetention()))
+	config.SetOutputSpillMax(sizeSetting(*outputMax, "OUTPUT_SPILL_LIMIT", config.GetOutputSpillMax()))
+	if err := spill.Load(); err != nil {
+		log.Fatalf("Failed to prepare output directory: %v", err)
+	}
+
+	// Get background job settings from flags or env and restore kept jobs
+	jobsDirectory := *jobsDir
+	if jobsDirectory == "" {
+		jobsDirectory = config.GetEnv("JOBS_DIR", "")
+	}
+	config.SetJobsDir(jobsDirectory)
+	config.SetMaxJobs(intSetting(*maxJobs, "MAX_JOBS", config.GetMaxJobs()))
+	config.SetJobRetention(durationSetting(*jobKeep, "JOB_RETENTION", config.GetJobRetention()))
+	config.SetJobOutputLimit(intSetting(*jobOutMax, "JOB_OUTPUT_LIMIT", config.GetJobOutputLimit()))
+	if err := jobs.Load(); err != nil {
+		log.Fatalf("Failed to load jobs: %v", err)
+	}
+
+	// Allow old terminal clients that do not negotiate the framed protocol
+	config.SetLegacyProtocol(boolSetting(*legacyWS, "LEGACY_PROTOCOL"))
+
+	// Get run-as user from flag or env and make sure we can switch to every configured user
+	runAsSpec := *runAs
+	if runAsSpec == "" {
+		runAsSpec = config.GetEnv("RUN_AS", "")
+	}
+	config.SetRunAs(runAsSpec)
+	checkRunAs(runAsSpec, "run-as")
+	for _, t := range config.GetTokens() {
+		checkRunAs(t.RunAs, "token "+t.Name)
+	}
+
+	// Get terminal shell settings from flags or env
+	shellPath := *shell
+	if shellPath == "" {
+		shellPath = config.GetEnv("TERMINAL_SHELL", "")
+	}
+	shellArgList := *shellArgs
+	if shellArgList == "" {
+		shellArgList = config.GetEnv("TERMINAL_SHELL_ARGS", "")
+	}
+	config.SetShell(shellPath, strings.Fields(shellArgList))
+	config.SetLoginShell(boolSetting(*login, "TERMINAL_LOGIN"))
+	workDir := *shellDir
+	if workDir == "" {
+		workDir = config.GetEnv("TERMINAL_CWD", "")
+	}
+	config.SetShellDir(workDir)
+	extraEnv := []string(shellEnv)
+	if len(extraEnv) == 0 {
+		extraEnv = splitList(config.GetEnv("TERMINAL_ENV", ""))
+	}
+	config.SetShellEnv(extraEnv)
+	allowed := *shells
+	if allowed == "" {
+		allowed = config.GetEnv("ALLOWED_SHELLS", "")
+	}
+	config.SetAllowedShells(splitList(allowed))
+	defaultShell, _ := config.GetShell()
+	log.Printf("Terminal shell: %s (allowed: %s)", defaultShell, strings.Join(config.GetAllowedShells(), ", "))
+
+	// Get terminal recording settings from flags or env
+	recordingDir := *recordDir
+	if recordingDir == "" {
+		recordingDir = config.GetEnv("RECORDING_DIR", "")
+	}
+	config.SetRecordingDir(recordingDir)
+	config.SetRecordInput(boolSetting(*recordIn, "RECORD_INPUT"))
+	config.SetRecordingRetention(
+		durationSetting(*recordAge, "RECORDING_MAX_AGE", 0),
+		intSetting(*recordMax, "RECORDING_MAX_FILES", 0),
+	)
+	if config.RecordingEnabled() {
+		log.Printf("Recording terminal sessions to %s", recordingDir)
+		recording.RunRetention(time.Hour)
+	}
+
 	setupRoutes(pathPrefix)
 
 	// Get certificate and key file paths
@@ -77,6 +324,11 @@ func main() {
 	log.Printf("  - Execute: %sexecute", pathPrefix)
 	log.Printf("  - Terminal: %sterminal", pathPrefix)
 	log.Printf("  - WebSocket: %sws", pathPrefix)
+	log.Printf("  - Jobs: %sjobs", pathPrefix)
+	log.Printf("  - Output: %soutput", pathPrefix)
+	log.Printf("  - Policy: %spolicy/evaluate", pathPrefix)
+	log.Printf("  - Sessions: %ssessions", pathPrefix)
+	log.Printf("  - Recordings: %srecordings", pathPrefix)
 	log.Printf("  - Upload: %supload", pathPrefix)
 	log.Printf("  - Download: %sdownload", pathPrefix)
 
@@ -115,6 +367,122 @@ func normalizePath(path string) string {
 	return path
 }
 
+// durationSetting resolves a duration from a flag value or env, exiting on invalid input
+func durationSetting(flagValue, envKey string, defaultValue time.Duration) time.Duration {
+	value := flagValue
+	if value == "" {
+		value = config.GetEnv(envKey, "")
+	}
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid duration %q for %s: %v", value, envKey, err)
+	}
+	return d
+}
+
+// checkRunAs exits if a run-as spec cannot be resolved or the server lacks
+// the privilege to switch to it
+func checkRunAs(spec, source string) {
+	cred, err := process.LookupUser(spec)
+	if err != nil {
+		log.Fatalf("Invalid run-as user for %s: %v", source, err)
+	}
+	if err := process.CheckPrivilege(cred); err != nil {
+		log.Fatalf("Refusing to start: %s: %v", source, err)
+	}
+	if cred != nil {
+		log.Printf("Processes for %s run as %s", source, cred)
+	}
+}
+
+// listFlag is a flag that may be given multiple times
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// boolSetting resolves a boolean from a flag value or env
+func boolSetting(flagValue bool, envKey string) bool {
+	if flagValue {
+		return true
+	}
+	value, err := strconv.ParseBool(config.GetEnv(envKey, "false"))
+	if err != nil {
+		log.Fatalf("Invalid boolean for %s: %v", envKey, err)
+	}
+	return value
+}
+
+// intSetting resolves an integer from a flag value or env, exiting on invalid input
+func intSetting(flagValue int, envKey string, defaultValue int) int {
+	if flagValue != 0 {
+		return flagValue
+	}
+	value := config.GetEnv(envKey, "")
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Invalid integer %q for %s: %v", value, envKey, err)
+	}
+	return n
+}
+
+// cgroupSetting resolves a cgroup limit from a flag value or env and converts
+// it with parse, exiting on invalid input
+func cgroupSetting(flagValue, envKey string, parse func(string) (string, error)) string {
+	value := flagValue
+	if value == "" {
+		value = config.GetEnv(envKey, "")
+	}
+	limit, err := parse(value)
+	if err != nil {
+		log.Fatalf("Invalid value for %s: %v", envKey, err)
+	}
+	return limit
+}
+
+// sizeSetting resolves a byte size such as 10M from a flag value or env, 0
+// meaning no limit, exiting on invalid input
+func sizeSetting(flagValue, envKey string, defaultValue int64) int64 {
+	value := flagValue
+	if value == "" {
+		value = config.GetEnv(envKey, "")
+	}
+	if value == "" {
+		return defaultValue
+	}
+	if value == "0" {
+		return 0
+	}
+	n, err := size.Parse(value)
+	if err != nil {
+		log.Fatalf("Invalid size %q for %s, expected bytes or a K, M, G or T suffix", value, envKey)
+	}
+	return n
+}
+
 func setupRoutes(pathPrefix string) {
 	// Public routes
 	http.HandleFunc(pathPrefix, handler.Home)
@@ -124,6 +492,19 @@ func setupRoutes(pathPrefix string) {
 	http.HandleFunc(pathPrefix+"execute", auth.AuthMiddleware(handler.ExecuteCommand))
 	http.HandleFunc(pathPrefix+"terminal", auth.AuthMiddleware(handler.TerminalPage))
 	http.HandleFunc(pathPrefix+"ws", auth.AuthMiddleware(terminal.WebSocket))
+	http.HandleFunc(pathPrefix+"jobs", auth.AuthMiddleware(handler.Jobs))
+	http.HandleFunc(pathPrefix+"jobs/", auth.AuthMiddleware(stripPrefix(pathPrefix+"jobs/", handler.Job)))
+	http.HandleFunc(pathPrefix+"output/", auth.AuthMiddleware(stripPrefix(pathPrefix+"output/", handler.Output)))
+	http.HandleFunc(pathPrefix+"policy/evaluate", auth.AuthMiddleware(handler.PolicyEvaluate))
+	http.HandleFunc(pathPrefix+"sessions", auth.AuthMiddleware(handler.Sessions))
+	http.HandleFunc(pathPrefix+"sessions/", auth.AuthMiddleware(stripPrefix(pathPrefix+"sessions/", handler.Session)))
+	http.HandleFunc(pathPrefix+"recordings", auth.AuthMiddleware(handler.Recordings))
+	http.HandleFunc(pathPrefix+"recordings/", auth.AuthMiddleware(stripPrefix(pathPrefix+"recordings/", handler.Recording)))
 	http.HandleFunc(pathPrefix+"upload", auth.AuthMiddleware(handler.UploadFile))
 	http.HandleFunc(pathPrefix+"download", auth.AuthMiddleware(handler.DownloadFile))
 }
+
+// stripPrefix removes prefix from the request path before calling next
+func stripPrefix(prefix string, next http.HandlerFunc) http.HandlerFunc {
+	return http.StripPrefix(prefix, next).ServeHTTP
+}